@@ -8,6 +8,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"sync"
 	"syscall"
@@ -70,24 +71,73 @@ func setup() (*api.Server, func(), error) {
 		}
 	}
 
-	client, err := kgo.NewClient(
-		kgo.SeedBrokers(os.Getenv("KAFKA_BROKERS")),
-		kgo.AllowAutoTopicCreation(),
-	)
-	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to create broker client: %v", err)
+	// Each producer client runs its own batching and connection goroutines, so requests are produced on several
+	// cores. A transactional client holds one request's transaction at a time, without transactions a request's
+	// records are spread over the clients by partition.
+	numProducers := runtime.NumCPU()
+	if v := os.Getenv("KAFKA_PRODUCERS"); v != "" {
+		numProducers, err = strconv.Atoi(v)
+		if err != nil || numProducers <= 0 {
+			return nil, cleanup, fmt.Errorf("invalid KAFKA_PRODUCERS value: %v", v)
+		}
+	}
+
+	transactional := true
+	if v := os.Getenv("KAFKA_TRANSACTIONS"); v != "" {
+		transactional, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("invalid KAFKA_TRANSACTIONS value: %v", v)
+		}
 	}
-	closures = append(closures, client.Close)
 
-	pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	err = client.Ping(pingCtx)
-	cancel()
+	// Transactional IDs must be unique across API instances, or each instance fences the other's producers
+	hostname, err := os.Hostname()
 	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to ping broker client: %v", err)
+		return nil, cleanup, fmt.Errorf("failed to get hostname: %v", err)
 	}
 
+	clients := make([]*kgo.Client, numProducers)
+	for i := range numProducers {
+		opts := []kgo.Opt{
+			kgo.SeedBrokers(os.Getenv("KAFKA_BROKERS")),
+			kgo.AllowAutoTopicCreation(),
+		}
+		if transactional {
+			opts = append(opts, kgo.TransactionalID(fmt.Sprintf("api-%s-%d", hostname, i)))
+		}
+		client, err := kgo.NewClient(opts...)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to create broker client: %v", err)
+		}
+		closures = append(closures, client.Close)
+
+		pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = client.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to ping broker client: %v", err)
+		}
+
+		clients[i] = client
+	}
+	log.Info("Created producer clients", slog.Int("count", numProducers), slog.Bool("transactional", transactional))
+
+	var producer *api.Producer
+	if transactional {
+		producer = api.NewTransactionalProducer(clients...)
+	} else {
+		producer = api.NewProducer(clients...)
+	}
+	// Also closes clients recreated after a failed abort
+	closures = append(closures, producer.Close)
+
 	shards := storage.NewShardedStore(log, pools)
-	server := api.NewServer(log, shards, client)
+	server := api.NewServer(log, shards, producer)
+	if mode := os.Getenv("VALIDATION_MODE"); mode != "" {
+		if err := server.SetValidationMode(mode); err != nil {
+			return nil, cleanup, err
+		}
+	}
 
 	return server, cleanup, nil
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const usage = `Usage: dlq <command> [flags]
+
+Commands:
+  list      Print dead letters as JSON lines
+  redrive   Produce dead letters back to the transactions topic
+
+Flags:
+`
+
+// One dead letter as printed by list
+type entry struct {
+	Partition       int32        `json:"partition"`
+	Offset          int64        `json:"offset"`
+	SourcePartition string       `json:"source_partition"`
+	SourceOffset    string       `json:"source_offset"`
+	Error           string       `json:"error"`
+	Timestamp       time.Time    `json:"timestamp"`
+	BatchID         *uuid.UUID   `json:"batch_id,omitempty"`
+	Transaction     *transaction `json:"transaction,omitempty"` // Unset when the value does not decode
+	Value           []byte       `json:"value,omitempty"`       // Raw value, only for records that do not decode
+}
+
+type transaction struct {
+	ID          string `json:"id"`
+	AccountID   string `json:"account_id"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency,omitempty"`
+	OccurredAt  int64  `json:"occurred_at,omitempty"`
+	ExternalRef string `json:"external_ref,omitempty"`
+	Metadata    string `json:"metadata,omitempty"`
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	flags := flag.NewFlagSet("dlq", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	limit := flags.Int("limit", 0, "Stop after this many dead letters, 0 for all")
+	partition := flags.Int("partition", -1, "Only dead letters in this DLQ partition")
+	offset := flags.Int64("offset", -1, "Only the dead letter at this offset, requires -partition")
+	errorContains := flags.String("error", "", "Only dead letters whose error contains this text")
+
+	if len(os.Args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	flags.Parse(os.Args[2:])
+
+	if *offset >= 0 && *partition < 0 {
+		fmt.Fprintln(os.Stderr, "-offset requires -partition")
+		os.Exit(2)
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(os.Getenv("KAFKA_BROKERS")),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create broker client: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	match := func(rec *kgo.Record) bool {
+		if *partition >= 0 && rec.Partition != int32(*partition) {
+			return false
+		}
+		if *offset >= 0 && rec.Offset != *offset {
+			return false
+		}
+		return *errorContains == "" || strings.Contains(header(rec, model.DeadLetterErrorHeader), *errorContains)
+	}
+
+	switch command {
+	case "list":
+		enc := json.NewEncoder(os.Stdout)
+		err = scan(ctx, client, *limit, match, func(rec *kgo.Record) error {
+			return enc.Encode(newEntry(rec))
+		})
+	case "redrive":
+		var count int
+		err = scan(ctx, client, *limit, match, func(rec *kgo.Record) error {
+			if err := client.ProduceSync(ctx, redriveRecord(rec)).FirstErr(); err != nil {
+				return fmt.Errorf("failed to redrive %d/%d: %v", rec.Partition, rec.Offset, err)
+			}
+			count++
+			return nil
+		})
+		fmt.Fprintf(os.Stderr, "Redrove %d dead letters\n", count)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+/*
+** Reads the DLQ from the start up to the end offsets it had when the scan began, so the command finishes rather
+** than waiting for new dead letters. Workers produce dead letters outside of transactions, so every offset up to
+** the end holds a record.
+ */
+func scan(ctx context.Context, client *kgo.Client, limit int, match func(*kgo.Record) bool, fn func(*kgo.Record) error) error {
+	adm := kadm.NewClient(client)
+	starts, err := adm.ListStartOffsets(ctx, model.DeadLetterTopic)
+	if err != nil {
+		return fmt.Errorf("failed to list start offsets: %v", err)
+	}
+	ends, err := adm.ListEndOffsets(ctx, model.DeadLetterTopic)
+	if err != nil {
+		return fmt.Errorf("failed to list end offsets: %v", err)
+	}
+
+	// A partition retention has emptied has nothing left to fetch, waiting for its end would never return
+	remaining := make(map[int32]int64)
+	offsets := make(map[int32]kgo.Offset)
+	ends.Each(func(o kadm.ListedOffset) {
+		start, ok := starts.Lookup(model.DeadLetterTopic, o.Partition)
+		if o.Err != nil || !ok || start.Err != nil || start.Offset >= o.Offset {
+			return
+		}
+		remaining[o.Partition] = o.Offset
+		offsets[o.Partition] = kgo.NewOffset().At(start.Offset)
+	})
+	if len(remaining) == 0 {
+		return nil
+	}
+	client.AddConsumePartitions(map[string]map[int32]kgo.Offset{model.DeadLetterTopic: offsets})
+
+	seen := 0
+	for len(remaining) > 0 {
+		fetches := client.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var fetchErr error
+		fetches.EachError(func(topic string, partition int32, err error) {
+			fetchErr = fmt.Errorf("failed to fetch %s/%d: %v", topic, partition, err)
+		})
+		if fetchErr != nil {
+			return fetchErr
+		}
+
+		iter := fetches.RecordIter()
+		for !iter.Done() {
+			rec := iter.Next()
+			end, ok := remaining[rec.Partition]
+			if !ok {
+				continue
+			}
+			if rec.Offset+1 >= end {
+				delete(remaining, rec.Partition)
+			}
+
+			if !match(rec) {
+				continue
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+			seen++
+			if limit > 0 && seen >= limit {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func newEntry(rec *kgo.Record) entry {
+	e := entry{
+		Partition:       rec.Partition,
+		Offset:          rec.Offset,
+		SourcePartition: header(rec, model.DeadLetterPartitionHeader),
+		SourceOffset:    header(rec, model.DeadLetterOffsetHeader),
+		Error:           header(rec, model.DeadLetterErrorHeader),
+		Timestamp:       rec.Timestamp,
+	}
+	if v := header(rec, model.BatchIDHeader); len(v) == 16 {
+		id := uuid.UUID([]byte(v))
+		e.BatchID = &id
+	}
+
+	var tx pb.Transaction
+	if err := tx.UnmarshalVT(rec.Value); err != nil {
+		e.Value = rec.Value
+		return e
+	}
+	e.Transaction = &transaction{
+		ID:          fmt.Sprintf("%x", tx.Id),
+		AccountID:   fmt.Sprintf("%x", tx.AccountId),
+		Amount:      tx.Amount,
+		Currency:    tx.Currency,
+		OccurredAt:  tx.OccurredAt,
+		ExternalRef: tx.ExternalRef,
+		Metadata:    string(tx.Metadata),
+	}
+	if len(tx.Id) == 16 {
+		e.Transaction.ID = uuid.UUID(tx.Id).String()
+	}
+	if len(tx.AccountId) == 16 {
+		e.Transaction.AccountID = uuid.UUID(tx.AccountId).String()
+	}
+	return e
+}
+
+// Rebuilds the original record, the dead-letter headers are dropped and the rest kept. Transactions are deduplicated
+// by ID, so re-driving a record that was already applied is harmless.
+func redriveRecord(rec *kgo.Record) *kgo.Record {
+	headers := make([]kgo.RecordHeader, 0, len(rec.Headers))
+	for _, h := range rec.Headers {
+		switch h.Key {
+		case model.DeadLetterErrorHeader, model.DeadLetterPartitionHeader, model.DeadLetterOffsetHeader:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	return &kgo.Record{
+		Topic:   "transactions",
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: headers,
+	}
+}
+
+func header(rec *kgo.Record, key string) string {
+	for _, h := range rec.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
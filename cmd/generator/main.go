@@ -91,6 +91,7 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// Batches are replayed for the whole attack, run workers with --load-test so they are not deduplicated
 	batches := setup(numFiles, batchSize)
 	counter := 0
 	targeter := func(t *vegeta.Target) error {
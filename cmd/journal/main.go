@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/logger"
+	"github.com/alexmcook/transaction-ledger/internal/worker"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func ensureTopicExists(ctx context.Context, client *kgo.Client, topic string) error {
+	adm := kadm.NewClient(client)
+
+	topics, err := adm.ListTopics(ctx, topic)
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %v", err)
+	}
+
+	if topics.Has(topic) {
+		return nil
+	}
+
+	if _, err := adm.CreateTopics(ctx, 64, 1, nil, topic); err != nil {
+		return fmt.Errorf("failed to create topic: %v", err)
+	}
+
+	return nil
+}
+
+func setup() (*worker.JournalProcessor, func(), error) {
+	var closures []func()
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			for i := len(closures) - 1; i >= 0; i-- {
+				closures[i]()
+			}
+		})
+	}
+
+	var log *slog.Logger
+	if os.Getenv("ENV") == "production" {
+		log = logger.NewLogger(slog.LevelInfo)
+	} else {
+		log = logger.NewLogger(slog.LevelDebug)
+	}
+	log.Info("Starting transaction ledger journal processor")
+
+	numShards, err := strconv.Atoi(os.Getenv("NUM_SHARDS"))
+	if err != nil || numShards <= 0 {
+		return nil, cleanup, fmt.Errorf("invalid NUM_SHARDS value: %v", os.Getenv("NUM_SHARDS"))
+	}
+
+	dbUrlEnv, ok := os.LookupEnv("DATABASE_URL")
+	if !ok {
+		return nil, cleanup, fmt.Errorf("DATABASE_URL environment variable not set")
+	}
+
+	pools := make([]*pgxpool.Pool, numShards)
+	for i := range numShards {
+		dbUrl := fmt.Sprintf(dbUrlEnv, i+1) // postgres-%d
+
+		pool, err := pgxpool.New(context.Background(), dbUrl)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to connect to database shard: %v", err)
+		}
+		closures = append(closures, pool.Close)
+
+		pools[i] = pool
+
+		pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = pool.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to ping database: %v", err)
+		}
+	}
+
+	// Offsets are committed only after an entry is applied or rejected, redelivered entries are skipped by ID
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(os.Getenv("KAFKA_BROKERS")),
+		kgo.ConsumerGroup("journal-processor"),
+		kgo.ConsumeTopics("journal"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.DisableAutoCommit(),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()), // Transfers in an aborted API transaction are never applied
+	)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("failed to create broker client: %v", err)
+	}
+	closures = append(closures, client.Close)
+
+	pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Ping(pingCtx)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("failed to ping broker client: %v", err)
+	}
+
+	topicCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ensureTopicExists(topicCtx, client, "journal"); err != nil {
+		return nil, cleanup, err
+	}
+
+	return worker.NewJournalProcessor(log, client, pools), cleanup, nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := http.ListenAndServe(":6060", nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start pprof server: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		http.ListenAndServe(":8080", nil)
+	}()
+
+	processor, cleanup, err := setup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up journal processor: %v\n", err)
+		cleanup()
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := processor.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Journal processor stopped: %v\n", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	fmt.Println("Shutting down...")
+
+	// Let the entry in flight finish committing before the connections are closed
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+
+	cleanup()
+	fmt.Println("Journal processor stopped")
+}
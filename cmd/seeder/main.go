@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"os"
 
+	"github.com/alexmcook/transaction-ledger/internal/storage"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
@@ -50,8 +50,7 @@ func makeUUIDs(n int) ([]uuid.UUID, error) {
 }
 
 func getShard(uid uuid.UUID, numShards int) int {
-	val := binary.BigEndian.Uint64(uid[8:16])
-	return int(val % uint64(numShards))
+	return storage.ShardForPartition(storage.PartitionForAccount(uid), numShards)
 }
 
 func truncateTables(pools []*pgxpool.Pool) error {
@@ -9,11 +9,13 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/alexmcook/transaction-ledger/internal/logger"
+	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/alexmcook/transaction-ledger/internal/storage"
 	"github.com/alexmcook/transaction-ledger/internal/worker"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -41,36 +43,28 @@ func ensureTopicExists(ctx context.Context, client *kgo.Client, topic string) er
 	return nil
 }
 
-func getPartitionOffsets(pool *pgxpool.Pool, minPart int, maxPart int) (map[string]map[int32]kgo.Offset, error) {
-	assignments := make(map[int32]kgo.Offset)
-	for i := minPart; i <= maxPart; i++ {
-		assignments[int32(i)] = kgo.NewOffset().AtStart()
-	}
-
-	const query = `SELECT partition_id, last_offset FROM kafka_offsets WHERE partition_id BETWEEN $1 AND $2`
-	rows, err := pool.Query(context.Background(), query, minPart, maxPart)
+func connectPool(dbUrl string, maxConns int) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dbUrl)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to parse database URL: %v", err)
 	}
-	defer rows.Close()
+	config.MaxConns = int32(maxConns)
 
-	for rows.Next() {
-		var partitionID int32
-		var offset int64
-		if err := rows.Scan(&partitionID, &offset); err != nil {
-			return nil, err
-		}
-		assignments[partitionID] = kgo.NewOffset().At(offset + 1)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pool.Ping(pingCtx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
-
-	return map[string]map[int32]kgo.Offset{"transactions": assignments}, nil
+	return pool, nil
 }
 
-func setup(minPart int, maxPart int) (*worker.Coordinator, func(), error) {
+func setup(minPart int, maxPart int, group string, loadTest bool, batch worker.BatchConfig) (*worker.Coordinator, func(), error) {
 	var closures []func()
 	var once sync.Once
 	cleanup := func() {
@@ -87,52 +81,74 @@ func setup(minPart int, maxPart int) (*worker.Coordinator, func(), error) {
 	} else {
 		log = logger.NewLogger(slog.LevelDebug)
 	}
-	log.Info(fmt.Sprintf("Starting transaction ledger worker for partitions %d-%d", minPart, maxPart))
 
 	dbUrl, ok := os.LookupEnv("DATABASE_URL")
 	if !ok {
 		return nil, cleanup, fmt.Errorf("DATABASE_URL environment variable not set")
 	}
 
-	config, err := pgxpool.ParseConfig(dbUrl)
-	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to parse database URL: %v", err)
-	}
-	config.MaxConns = int32((maxPart - minPart + 1) + 4)
+	clientOpts := []kgo.Opt{
+		kgo.SeedBrokers(os.Getenv("KAFKA_BROKERS")),
+		kgo.BrokerMaxReadBytes(512 * 1024 * 1024),
+		kgo.FetchMaxBytes(256 * 1024 * 1024),
+		kgo.FetchMaxPartitionBytes(16 * 1024 * 1024),
+		// The API produces each request as a Kafka transaction, records of aborted requests are skipped so only the
+		// client's retry is written
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	}
+
+	var coordinator *worker.Coordinator
+	if group != "" {
+		log.Info(fmt.Sprintf("Starting transaction ledger worker in consumer group %s", group))
+
+		// Any partition may be assigned, so every shard is connected, DATABASE_URL is formatted with the shard
+		// number as for the API
+		numShards, err := strconv.Atoi(os.Getenv("NUM_SHARDS"))
+		if err != nil || numShards <= 0 {
+			return nil, cleanup, fmt.Errorf("invalid NUM_SHARDS value: %v", os.Getenv("NUM_SHARDS"))
+		}
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
-	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to connect to database: %v", err)
-	}
-	closures = append(closures, pool.Close)
+		pools := make([]*pgxpool.Pool, numShards)
+		stores := make([]*storage.PostgresStore, numShards)
+		for i := range numShards {
+			pool, err := connectPool(fmt.Sprintf(dbUrl, i+1), storage.NumPartitions/numShards+4)
+			if err != nil {
+				return nil, cleanup, err
+			}
+			closures = append(closures, pool.Close)
 
-	pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err = pool.Ping(pingCtx)
-	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to ping database: %v", err)
-	}
+			pools[i] = pool
+			stores[i] = storage.NewPostgresStore(log, pool)
+			stores[i].Transactions().SetLoadTestMode(loadTest)
+		}
 
-	topicPartitions, err := getPartitionOffsets(pool, minPart, maxPart)
-	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to get partition offsets: %v", err)
-	}
+		coordinator, err = worker.NewGroupCoordinator(context.Background(), log, group, stores, pools, batch, clientOpts...)
+		if err != nil {
+			return nil, cleanup, err
+		}
+	} else {
+		log.Info(fmt.Sprintf("Starting transaction ledger worker for partitions %d-%d", minPart, maxPart))
 
-	client, err := kgo.NewClient(
-		kgo.SeedBrokers(os.Getenv("KAFKA_BROKERS")),
-		kgo.ConsumePartitions(topicPartitions),
-		kgo.BrokerMaxReadBytes(512*1024*1024),
-		kgo.FetchMaxBytes(256*1024*1024),
-		kgo.FetchMaxPartitionBytes(16*1024*1024),
-	)
-	if err != nil {
-		return nil, cleanup, fmt.Errorf("failed to create broker client: %v", err)
+		pool, err := connectPool(dbUrl, (maxPart-minPart+1)+4)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		closures = append(closures, pool.Close)
+
+		dbStore := storage.NewPostgresStore(log, pool)
+		dbStore.Transactions().SetLoadTestMode(loadTest)
+		coordinator, err = worker.NewCoordinator(context.Background(), minPart, maxPart, log, dbStore, pool, batch, clientOpts...)
+		if err != nil {
+			return nil, cleanup, err
+		}
 	}
-	closures = append(closures, client.Close)
+	// The coordinator replaces its client if it fails, so the current one is closed rather than this one
+	closures = append(closures, coordinator.Close)
+	client := coordinator.Client()
 
-	pingCtx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	err = client.Ping(pingCtx)
+	err := client.Ping(pingCtx)
 	if err != nil {
 		return nil, cleanup, fmt.Errorf("failed to ping broker client: %v", err)
 	}
@@ -140,9 +156,9 @@ func setup(minPart int, maxPart int) (*worker.Coordinator, func(), error) {
 	topicCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	err = ensureTopicExists(topicCtx, client, "transactions")
-
-	dbStore := storage.NewPostgresStore(log, pool)
-	coordinator := worker.NewCoordinator(context.Background(), minPart, maxPart, log, dbStore, client, pool)
+	if err := ensureTopicExists(topicCtx, client, model.DeadLetterTopic); err != nil {
+		return nil, cleanup, fmt.Errorf("failed to create dead-letter topic: %v", err)
+	}
 
 	return coordinator, cleanup, nil
 }
@@ -164,15 +180,30 @@ func main() {
 	defer stop()
 
 	partitionRange := flag.String("partitions", "0-63", "Range of partitions to consume, e.g. '0-3'")
+	group := flag.String("group", "", "Join this consumer group and take partitions as the broker assigns them instead of a fixed --partitions range")
+	loadTest := flag.Bool("load-test", false, "Rewrite transaction IDs so replayed generator batches are not deduplicated, never use in production")
+	batch := worker.DefaultBatchConfig()
+	flag.IntVar(&batch.MaxRecords, "batch-records", batch.MaxRecords, "Most records in a batch handed to a partition writer")
+	flag.IntVar(&batch.MaxBytes, "batch-bytes", batch.MaxBytes, "Most bytes of record values in a batch, a batch is flushed early rather than exceed it")
+	flag.DurationVar(&batch.Linger, "batch-linger", batch.Linger, "How long a batch may wait for more records, 0 flushes after every poll")
 	flag.Parse()
 
+	if err := batch.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid batch config: %v\n", err)
+		os.Exit(1)
+	}
+
 	minPartition, maxPartition, err := parsePartitionRange(*partitionRange)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid partition range: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Worker starting on partitions: %s\n", *partitionRange)
+	if *group != "" {
+		fmt.Printf("Worker starting in consumer group: %s\n", *group)
+	} else {
+		fmt.Printf("Worker starting on partitions: %s\n", *partitionRange)
+	}
 
 	go func() {
 		if err := http.ListenAndServe(":6060", nil); err != nil {
@@ -186,7 +217,7 @@ func main() {
 		http.ListenAndServe(":8080", nil)
 	}()
 
-	coordinator, cleanup, err := setup(minPartition, maxPartition)
+	coordinator, cleanup, err := setup(minPartition, maxPartition, *group, *loadTest, batch)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to set up server: %v\n", err)
 		cleanup()
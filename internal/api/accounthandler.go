@@ -1,12 +1,21 @@
 package api
 
 import (
+	"errors"
 	"log/slog"
+	"strconv"
 
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/alexmcook/transaction-ledger/internal/storage"
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
 )
 
+const (
+	defaultAccountPageSize = 100
+	maxAccountPageSize     = 1000
+)
+
 func (s *Server) handleGetAccount(c fiber.Ctx) error {
 	idStr := c.Params("id")
 	id, err := uuid.Parse(idStr)
@@ -31,9 +40,188 @@ func (s *Server) handleGetAccount(c fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(AccountResponse{
-		ID:        account.ID,
-		Balance:   account.Balance,
-		CreatedAt: account.CreatedAt,
-	})
+	return c.JSON(newAccountResponse(account))
+}
+
+func (s *Server) handleCreateAccount(c fiber.Ctx) error {
+	var body CreateAccountRequest
+	if len(c.Body()) > 0 {
+		if err := c.Bind().JSON(&body); err != nil {
+			s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid request body",
+			})
+		}
+	}
+
+	id := body.ID
+	if id == uuid.Nil {
+		var err error
+		id, err = uuid.NewV7()
+		if err != nil {
+			s.log.ErrorContext(c.Context(), "Failed to generate account ID", slog.Any("error", err))
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Message: "Failed to create account",
+			})
+		}
+	}
+
+	currency := body.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if !validCurrencyCode(currency) {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid currency, must be an ISO 4217 code",
+		})
+	}
+
+	account, err := s.store.CreateAccount(c.Context(), id, currency, body.MinBalance)
+	if err != nil {
+		if errors.Is(err, storage.ErrAccountExists) {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Message: "Account already exists",
+			})
+		}
+		if errors.Is(err, storage.ErrUnknownCurrency) {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Unknown currency",
+			})
+		}
+		s.log.ErrorContext(c.Context(), "Failed to create account", slog.String("id", id.String()), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to create account",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(newAccountResponse(account))
+}
+
+func (s *Server) handleListAccounts(c fiber.Ctx) error {
+	var after uuid.UUID
+	if afterStr := c.Query("after"); afterStr != "" {
+		var err error
+		after, err = uuid.Parse(afterStr)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid after cursor",
+			})
+		}
+	}
+
+	limit := defaultAccountPageSize
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxAccountPageSize {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid limit, must be between 1 and " + strconv.Itoa(maxAccountPageSize),
+			})
+		}
+	}
+
+	accounts, err := s.store.ListAccounts(c.Context(), after, limit)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to list accounts", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to list accounts",
+		})
+	}
+
+	resp := ListAccountsResponse{
+		Accounts: make([]AccountSummary, len(accounts)),
+	}
+	for i, account := range accounts {
+		resp.Accounts[i] = AccountSummary{
+			ID:               account.ID,
+			Currency:         account.Currency,
+			Balance:          account.Balance,
+			FormattedBalance: formatMinorUnits(account.Balance, account.MinorUnits),
+			MinBalance:       account.MinBalance,
+			Partition:        account.Partition,
+			Status:           account.Status,
+			CreatedAt:        account.CreatedAt,
+			UpdatedAt:        account.UpdatedAt,
+		}
+	}
+	if len(accounts) == limit {
+		resp.NextAfter = &accounts[len(accounts)-1].ID
+	}
+
+	return c.JSON(resp)
+}
+
+func (s *Server) handleUpdateAccount(c fiber.Ctx) error {
+	idStr := c.Params("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Invalid account ID format", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid account ID format",
+		})
+	}
+
+	var body UpdateAccountRequest
+	if err := c.Bind().JSON(&body); err != nil {
+		s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid request body",
+		})
+	}
+
+	if body.Status == "" && body.MinBalance == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Nothing to update, expected status or min_balance",
+		})
+	}
+
+	switch body.Status {
+	case "", model.AccountStatusActive, model.AccountStatusFrozen, model.AccountStatusClosed:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid status, must be one of active, frozen or closed",
+		})
+	}
+
+	account, err := s.store.UpdateAccount(c.Context(), id, body.Status, body.MinBalance)
+	if err != nil {
+		if errors.Is(err, storage.ErrAccountClosed) {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Message: "Account is closed",
+			})
+		}
+		s.log.ErrorContext(c.Context(), "Failed to update account", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to update account",
+		})
+	}
+
+	if account == nil {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Message: "Account not found",
+		})
+	}
+
+	return c.JSON(newAccountResponse(account))
+}
+
+func newAccountResponse(account *model.Account) AccountResponse {
+	current := account.Balance + account.PendingBalance
+	available := current - account.MinBalance
+	return AccountResponse{
+		ID:                      account.ID,
+		Currency:                account.Currency,
+		Balance:                 account.Balance,
+		PendingBalance:          account.PendingBalance,
+		CurrentBalance:          current,
+		FormattedCurrentBalance: formatMinorUnits(current, account.MinorUnits),
+		MinBalance:              account.MinBalance,
+		Available:               available,
+		FormattedAvailable:      formatMinorUnits(available, account.MinorUnits),
+		Partition:               account.Partition,
+		AsOfOffset:              account.LastOffset,
+		Status:                  account.Status,
+		CreatedAt:               account.CreatedAt,
+		UpdatedAt:               account.UpdatedAt,
+	}
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"log/slog"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	batchStatusPending  = "pending"  // Some records have not been staged or dead lettered by a worker yet
+	batchStatusMerged   = "merged"   // Every record was merged, rejected or deduplicated
+	batchStatusComplete = "complete" // Every merged record has also been written behind into balances
+)
+
+/*
+** Assigns an ingestion request its batch ID. The returned headers are shared by all of the batch's records, the
+** producer never modifies them.
+ */
+func newBatch() (uuid.UUID, []kgo.RecordHeader, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return id, []kgo.RecordHeader{{Key: model.BatchIDHeader, Value: id[:]}}, nil
+}
+
+func (s *Server) handleGetBatch(c fiber.Ctx) error {
+	idStr := c.Params("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Invalid batch ID format", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid batch ID format",
+		})
+	}
+
+	batch, err := s.store.GetBatch(c.Context(), id)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to retrieve batch", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to retrieve batch",
+		})
+	}
+
+	if batch == nil {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Message: "Batch not found",
+		})
+	}
+
+	return c.JSON(newBatchResponse(batch))
+}
+
+func newBatchResponse(batch *model.Batch) BatchResponse {
+	resp := BatchResponse{
+		ID:            batch.ID,
+		Produced:      batch.Produced,
+		Staged:        batch.Staged,
+		Merged:        batch.Merged,
+		Rejected:      batch.Rejected,
+		Duplicates:    batch.Staged - batch.Merged - batch.Rejected,
+		DeadLettered:  batch.DeadLettered,
+		WrittenBehind: batch.WrittenBehind,
+		CreatedAt:     batch.CreatedAt,
+	}
+
+	switch {
+	case batch.Staged+batch.DeadLettered < batch.Produced:
+		resp.Status = batchStatusPending
+	case batch.WrittenBehind < batch.Merged:
+		resp.Status = batchStatusMerged
+	default:
+		resp.Status = batchStatusComplete
+	}
+	return resp
+}
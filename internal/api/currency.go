@@ -0,0 +1,50 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Accounts created without a currency hold USD, matching the default of accounts created before currencies existed
+const defaultCurrency = "USD"
+
+// ISO 4217 alphabetic code, whether the currency is known is only checked against the currencies table
+func validCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for i := range len(code) {
+		if code[i] < 'A' || code[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+** Renders an amount in minor units as a decimal string, e.g. -1234 with 2 minor units is "-12.34". Works on the
+** unsigned magnitude so math.MinInt64 does not overflow.
+ */
+func formatMinorUnits(amount int64, minorUnits int16) string {
+	magnitude := uint64(amount)
+	if amount < 0 {
+		magnitude = -magnitude
+	}
+
+	digits := strconv.FormatUint(magnitude, 10)
+	units := int(minorUnits)
+	if len(digits) <= units {
+		digits = strings.Repeat("0", units-len(digits)+1) + digits
+	}
+
+	var b strings.Builder
+	if amount < 0 {
+		b.WriteByte('-')
+	}
+	b.WriteString(digits[:len(digits)-units])
+	if units > 0 {
+		b.WriteByte('.')
+		b.WriteString(digits[len(digits)-units:])
+	}
+	return b.String()
+}
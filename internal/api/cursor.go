@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/google/uuid"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+/*
+** Cursor tokens are opaque to clients: base64url of the created_at microseconds followed by the transaction ID
+ */
+func encodeTransactionCursor(cursor model.TransactionCursor) string {
+	buf := make([]byte, 0, 24)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(cursor.CreatedAt.UnixMicro()))
+	buf = append(buf, cursor.ID[:]...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeTransactionCursor(token string) (*model.TransactionCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != 24 {
+		return nil, errInvalidCursor
+	}
+
+	cursor := &model.TransactionCursor{
+		CreatedAt: time.UnixMicro(int64(binary.BigEndian.Uint64(buf[:8]))).UTC(),
+	}
+	copy(cursor.ID[:], buf[8:])
+	if cursor.ID == uuid.Nil {
+		return nil, errInvalidCursor
+	}
+	return cursor, nil
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+const (
+	maxExternalRefLength = 255
+	maxMetadataSize      = 4096
+)
+
+func isJSONObject(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(data)
+}
+
+// Event times travel as microseconds since the Unix epoch, 0 when the client did not supply one
+func occurredAtMicros(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMicro()
+}
+
+func metadataBytes(metadata json.RawMessage) []byte {
+	// An explicit null is the same as no metadata
+	if bytes.Equal(metadata, []byte("null")) {
+		return nil
+	}
+	return metadata
+}
@@ -21,6 +21,8 @@ func (s *Server) handleEfficientJSON(c fiber.Ctx) error {
 	defer func() {
 		// Clean up and return resources if not oversized
 		if cap(*bodyPtr) <= 1000 {
+			// Optional fields omitted by the next request would otherwise keep this request's values
+			clear(*bodyPtr)
 			*bodyPtr = (*bodyPtr)[:0]
 			trPool.Put(bodyPtr)
 		}
@@ -30,24 +32,55 @@ func (s *Server) handleEfficientJSON(c fiber.Ctx) error {
 		}
 	}()
 
-	if len(c.Body()) > 100000*128 {
+	wait, msg := parseCommitWait(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: msg,
+		})
+	}
+
+	mode, msg := s.parseValidationMode(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: msg,
+		})
+	}
+
+	batchID, headers, err := newBatch()
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to generate batch ID", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to process transactions",
+		})
+	}
+
+	if len(c.Body()) > maxRequestBodySize {
 		s.log.ErrorContext(c.Context(), "Request body too large", slog.Int("size", len(c.Body())))
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Message: "Request body too large",
 		})
 	}
 
+	var body []TransactionRequest
+	var validator *batchValidator
 	unmarhshalStart := time.Now()
 	if err := sonic.Unmarshal(c.Body(), bodyPtr); err != nil {
-		s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Message: "Invalid request body",
-		})
+		// Find which elements are at fault, only a body that is not a JSON array at all fails without detail
+		validator = newBatchValidator(mode, 0)
+		body, err = decodeElements(c.Body(), sonic.Unmarshal, validator)
+		if err != nil {
+			s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid request body",
+			})
+		}
+	} else {
+		body = *bodyPtr
+		validator = newBatchValidator(mode, len(body))
 	}
 	unmarshalLatency.WithLabelValues("efficient_json").Observe(time.Since(unmarhshalStart).Seconds())
 
 	now := time.Now()
-	body := *bodyPtr
 	count = len(body)
 	var records []*kgo.Record
 	if count > 1000 {
@@ -75,10 +108,27 @@ func (s *Server) handleEfficientJSON(c fiber.Ctx) error {
 	s.log.DebugContext(c.Context(), "Creating transaction batch", slog.Int("count", count))
 
 	for i := range count {
+		body[i].Metadata = metadataBytes(body[i].Metadata)
+		validator.check(i, body[i].ID[:], body[i].AccountID[:], body[i].Amount, body[i].Currency, body[i].ExternalRef, body[i].Metadata)
+	}
+	if validator.rejectsBatch() {
+		return validator.respondInvalid(c)
+	}
+
+	produced := 0
+	for i := range count {
+		if validator.skipped(i) {
+			continue
+		}
+
 		tx := pb.Transaction{
-			Id:        body[i].ID[:],
-			AccountId: body[i].AccountID[:],
-			Amount:    body[i].Amount,
+			Id:          body[i].ID[:],
+			AccountId:   body[i].AccountID[:],
+			Amount:      body[i].Amount,
+			Currency:    body[i].Currency,
+			OccurredAt:  occurredAtMicros(body[i].OccurredAt),
+			ExternalRef: body[i].ExternalRef,
+			Metadata:    body[i].Metadata,
 		}
 
 		size := tx.SizeVT()
@@ -97,23 +147,25 @@ func (s *Server) handleEfficientJSON(c fiber.Ctx) error {
 			})
 		}
 
-		records[i].Topic = "transactions"
-		records[i].Value = payloadBuf
-		records[i].Key = body[i].AccountID[:]
-		records[i].Timestamp = now
+		record := records[produced]
+		record.Topic = "transactions"
+		record.Value = payloadBuf
+		record.Key = body[i].AccountID[:]
+		record.Timestamp = now
+		record.Headers = headers
+		produced++
 	}
+	records = records[:produced]
 
 	kafkaStart := time.Now()
-	if err := s.client.ProduceSync(c.Context(), records...).FirstErr(); err != nil {
+	if err := s.producer.Produce(c.Context(), records...); err != nil {
 		s.log.ErrorContext(c.Context(), "Failed to sync", slog.Any("error", err))
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Message: "Failed to sync transactions",
 		})
 	}
 	kafkaProducerLatency.Observe(time.Since(kafkaStart).Seconds())
-	kafkaTransactionsProduced.Add(float64(count))
+	kafkaTransactionsProduced.Add(float64(produced))
 
-	return c.Status(201).JSON(CreateTransactionResponse{
-		CreatedCount: count,
-	})
+	return s.respondProduced(c, batchID, produced, batchReceipts(records), wait, validator)
 }
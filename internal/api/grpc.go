@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/google/uuid"
+	vtgrpc "github.com/planetscale/vtprotobuf/codec/grpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+** gRPC front end for Go services. Ingestion goes through the same produce path as POST /transactions/proto, and
+** messages are encoded with the vtproto codecs rather than reflection.
+ */
+type grpcLedger struct {
+	pb.UnimplementedLedgerServer
+	s *Server
+}
+
+func newGRPCServer(s *Server) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(vtgrpc.Codec{}),
+		grpc.MaxRecvMsgSize(maxRequestBodySize),
+		grpc.ChainUnaryInterceptor(grpcUnaryMetrics),
+		grpc.ChainStreamInterceptor(grpcStreamMetrics),
+	)
+	pb.RegisterLedgerServer(server, &grpcLedger{s: s})
+	return server
+}
+
+func (g *grpcLedger) SubmitBatch(ctx context.Context, batch *pb.TransactionBatch) (*pb.SubmitBatchResponse, error) {
+	batchID, headers, err := newBatch()
+	if err != nil {
+		g.s.log.ErrorContext(ctx, "Failed to generate batch ID", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "Failed to process transactions")
+	}
+
+	mode, err := g.validationMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	validator := newBatchValidator(mode, len(batch.Transactions))
+	receipts, produced, err := g.s.produceProtoBatch(ctx, batch.Transactions, headers, validator)
+	if err != nil {
+		return nil, produceStatus(err, validator)
+	}
+	g.s.recordBatch(ctx, batchID, produced)
+
+	return newSubmitBatchResponse(batchID, produced, receipts, validator), nil
+}
+
+func (g *grpcLedger) SubmitStream(stream pb.Ledger_SubmitStreamServer) error {
+	ctx := stream.Context()
+	batchID, headers, err := newBatch()
+	if err != nil {
+		g.s.log.ErrorContext(ctx, "Failed to generate batch ID", slog.Any("error", err))
+		return status.Error(codes.Internal, "Failed to process transactions")
+	}
+
+	mode, err := g.validationMode(ctx)
+	if err != nil {
+		return err
+	}
+
+	// One validator for the whole stream, so duplicate IDs are caught across batches and indices count from the
+	// first record of the stream
+	validator := newBatchValidator(mode, 0)
+	var sent, count int
+	var receipts []Receipt
+	for {
+		batch, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		validator.base = sent
+		batchReceipts, produced, err := g.s.produceProtoBatch(ctx, batch.Transactions, headers, validator)
+		if err != nil {
+			// Batches already produced are applied, record them so the batch ID still reports on them
+			g.s.recordBatch(ctx, batchID, count)
+			return produceStatus(err, validator)
+		}
+		sent += len(batch.Transactions)
+		count += produced
+		receipts = mergeReceipts(receipts, batchReceipts)
+	}
+	g.s.recordBatch(ctx, batchID, count)
+
+	return stream.SendAndClose(newSubmitBatchResponse(batchID, count, receipts, validator))
+}
+
+func (g *grpcLedger) GetAccount(ctx context.Context, req *pb.GetAccountRequest) (*pb.Account, error) {
+	id, err := uuid.FromBytes(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid account ID format")
+	}
+
+	account, err := g.s.store.GetAccount(ctx, id)
+	if err != nil {
+		g.s.log.ErrorContext(ctx, "Failed to retrieve account", slog.String("id", id.String()), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "Failed to retrieve account")
+	}
+	if account == nil {
+		return nil, status.Error(codes.NotFound, "Account not found")
+	}
+
+	return &pb.Account{
+		Id:             account.ID[:],
+		Balance:        account.Balance,
+		PendingBalance: account.PendingBalance,
+		MinBalance:     account.MinBalance,
+		Currency:       account.Currency,
+		Status:         account.Status,
+		CreatedAt:      account.CreatedAt.UnixMicro(),
+		UpdatedAt:      account.UpdatedAt.UnixMicro(),
+	}, nil
+}
+
+func (g *grpcLedger) GetTransaction(ctx context.Context, req *pb.GetTransactionRequest) (*pb.TransactionRecord, error) {
+	id, err := uuid.FromBytes(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid transaction ID format")
+	}
+
+	transaction, err := g.s.store.GetTransaction(ctx, id)
+	if err != nil {
+		g.s.log.ErrorContext(ctx, "Failed to retrieve transaction", slog.String("id", id.String()), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "Failed to retrieve transaction")
+	}
+	if transaction == nil {
+		return nil, status.Error(codes.NotFound, "Transaction not found")
+	}
+
+	record := &pb.TransactionRecord{
+		Transaction: &pb.Transaction{
+			Id:          transaction.ID[:],
+			AccountId:   transaction.AccountID[:],
+			Amount:      transaction.Amount,
+			ExternalRef: transaction.ExternalRef,
+			Metadata:    transaction.Metadata,
+		},
+		CreatedAt: transaction.CreatedAt.UnixMicro(),
+		Stage:     transaction.Stage,
+		Reason:    transaction.Reason,
+	}
+	if transaction.OccurredAt != nil {
+		record.Transaction.OccurredAt = transaction.OccurredAt.UnixMicro()
+	}
+	return record, nil
+}
+
+// Takes the validation mode from the "validation" metadata entry, falling back to the server's default mode
+func (g *grpcLedger) validationMode(ctx context.Context) (string, error) {
+	values := metadata.ValueFromIncomingContext(ctx, "validation")
+	if len(values) == 0 {
+		return g.s.validationMode, nil
+	}
+	switch mode := values[0]; mode {
+	case validationStrict, validationPartial:
+		return mode, nil
+	default:
+		return "", status.Error(codes.InvalidArgument, "Invalid validation, must be strict or partial")
+	}
+}
+
+func newSubmitBatchResponse(batchID uuid.UUID, count int, receipts []Receipt, validator *batchValidator) *pb.SubmitBatchResponse {
+	resp := &pb.SubmitBatchResponse{
+		BatchId:      batchID[:],
+		CreatedCount: int32(count),
+		Receipts:     make([]*pb.Receipt, len(receipts)),
+		InvalidCount: int32(validator.invalid),
+		Errors:       recordErrors(validator.report()),
+	}
+	for i, r := range receipts {
+		resp.Receipts[i] = &pb.Receipt{
+			Partition: r.Partition,
+			Offset:    r.Offset,
+			Token:     r.Token,
+		}
+	}
+	return resp
+}
+
+func recordErrors(errs []RecordError) []*pb.RecordError {
+	out := make([]*pb.RecordError, len(errs))
+	for i, e := range errs {
+		out[i] = &pb.RecordError{
+			Index:   int32(e.Index),
+			Reason:  e.Reason,
+			Message: e.Message,
+		}
+	}
+	return out
+}
+
+/*
+** Maps produceProtoBatch errors to the status codes the HTTP handler's responses correspond to. Invalid records are
+** itemised in a BadRequest detail, the same list the HTTP handler returns in its body.
+ */
+func produceStatus(err error, validator *batchValidator) error {
+	var invalid *invalidBatchError
+	switch {
+	case errors.Is(err, errInvalidRecords):
+		st := status.New(codes.InvalidArgument, fmt.Sprintf("Invalid transactions, %d invalid records", validator.invalid))
+		report := &errdetails.BadRequest{}
+		for _, e := range validator.report() {
+			report.FieldViolations = append(report.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fmt.Sprintf("transactions[%d]", e.Index),
+				Description: e.Reason + ": " + e.Message,
+			})
+		}
+		if detailed, err := st.WithDetails(report); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, invalid.message)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, errProduceFailed):
+		return status.Error(codes.Unavailable, "Failed to sync transactions")
+	default:
+		return status.Error(codes.Internal, "Failed to process transactions")
+	}
+}
+
+func grpcUnaryMetrics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeGRPC(info.FullMethod, err, start)
+	return resp, err
+}
+
+func grpcStreamMetrics(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeGRPC(info.FullMethod, err, start)
+	return err
+}
+
+func observeGRPC(method string, err error, start time.Time) {
+	code := status.Code(err).String()
+	grpcRequestsTotal.WithLabelValues(method, code).Inc()
+	grpcRequestDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotencyKeyTTL    = 24 * time.Hour
+	idempotencyKeyLease  = 5 * time.Minute // In-flight claims older than this are assumed abandoned
+	maxIdempotencyKeyLen = 255
+)
+
+/*
+** Remembers the response to requests carrying an Idempotency-Key header. A retry with the same key replays the
+** stored response without running the handler, so the batch is never produced to Kafka a second time.
+ */
+func (s *Server) idempotencyMiddleware(c fiber.Ctx) error {
+	key := c.Get(idempotencyKeyHeader)
+	if key == "" {
+		return c.Next()
+	}
+
+	if len(key) > maxIdempotencyKeyLen {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Idempotency-Key too long",
+		})
+	}
+
+	// Bind the key to the request so it cannot be reused for a different payload or query, a retry that adds
+	// ?wait=committed would otherwise replay a response that never waited. Fields are separated so none runs into
+	// the next.
+	hasher := sha256.New()
+	hasher.Write([]byte(c.Path()))
+	hasher.Write([]byte{0})
+	hasher.Write(c.Request().URI().QueryString())
+	hasher.Write([]byte{0})
+	hasher.Write(c.Body())
+	requestHash := hasher.Sum(nil)
+
+	now := time.Now()
+	existing, claimed, err := s.store.ClaimIdempotencyKey(c.Context(), key, requestHash, now.Add(idempotencyKeyTTL), now.Add(-idempotencyKeyLease))
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to claim idempotency key", slog.String("key", key), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to process idempotency key",
+		})
+	}
+
+	if !claimed {
+		if subtle.ConstantTimeCompare(existing.RequestHash, requestHash) != 1 {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(ErrorResponse{
+				Message: "Idempotency-Key already used for a different request",
+			})
+		}
+		if existing.StatusCode == 0 {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Message: "Request with this Idempotency-Key is still in progress",
+			})
+		}
+
+		idempotentReplays.Inc()
+		c.Set("Idempotent-Replayed", "true")
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(existing.StatusCode).Send(existing.Response)
+	}
+
+	handlerErr := c.Next()
+
+	status := c.Response().StatusCode()
+	if handlerErr == nil && status >= 200 && status < 300 {
+		// Copy since the response buffer is reused by fasthttp
+		response := append([]byte(nil), c.Response().Body()...)
+		if err := s.store.CompleteIdempotencyKey(c.Context(), key, status, response); err != nil {
+			s.log.ErrorContext(c.Context(), "Failed to store idempotent response", slog.String("key", key), slog.Any("error", err))
+		}
+		return nil
+	}
+
+	// Nothing was produced (or it is unknown), release the key so the client can retry
+	if err := s.store.ReleaseIdempotencyKey(c.Context(), key); err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to release idempotency key", slog.String("key", key), slog.Any("error", err))
+	}
+	return handlerErr
+}
+
+func (s *Server) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.store.PurgeExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				s.log.ErrorContext(ctx, "Failed to purge expired idempotency keys", slog.Any("error", err))
+				continue
+			}
+			s.log.DebugContext(ctx, "Purged expired idempotency keys", slog.Int64("count", purged))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"log/slog"
 	"time"
 
@@ -13,24 +14,71 @@ import (
 func (s *Server) handleJSON(c fiber.Ctx) error {
 	var body []TransactionRequest
 
-	unmarshalStart := time.Now()
-	if err := c.Bind().JSON(&body); err != nil {
-		s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
+	wait, msg := parseCommitWait(c)
+	if msg != "" {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Message: "Invalid request body",
+			Message: msg,
 		})
 	}
+
+	mode, msg := s.parseValidationMode(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: msg,
+		})
+	}
+
+	batchID, headers, err := newBatch()
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to generate batch ID", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to process transactions",
+		})
+	}
+
+	var validator *batchValidator
+	unmarshalStart := time.Now()
+	if err := c.Bind().JSON(&body); err != nil {
+		// Find which elements are at fault, only a body that is not a JSON array at all fails without detail
+		validator = newBatchValidator(mode, 0)
+		body, err = decodeElements(c.Body(), json.Unmarshal, validator)
+		if err != nil {
+			s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid request body",
+			})
+		}
+	} else {
+		validator = newBatchValidator(mode, len(body))
+	}
 	unmarshalLatency.WithLabelValues("json").Observe(time.Since(unmarshalStart).Seconds())
 
 	s.log.DebugContext(c.Context(), "Creating transaction batch", slog.Int("count", len(body)))
 
-	records := make([]*kgo.Record, len(body))
+	metadata := make([][]byte, len(body))
+	for i := range body {
+		metadata[i] = metadataBytes(body[i].Metadata)
+		validator.check(i, body[i].ID[:], body[i].AccountID[:], body[i].Amount, body[i].Currency, body[i].ExternalRef, metadata[i])
+	}
+	if validator.rejectsBatch() {
+		return validator.respondInvalid(c)
+	}
+
+	records := make([]*kgo.Record, 0, len(body))
 
 	for i := range body {
+		if validator.skipped(i) {
+			continue
+		}
+
 		payload, err := proto.Marshal(&pb.Transaction{
-			Id:        body[i].ID[:],
-			AccountId: body[i].AccountID[:],
-			Amount:    body[i].Amount,
+			Id:          body[i].ID[:],
+			AccountId:   body[i].AccountID[:],
+			Amount:      body[i].Amount,
+			Currency:    body[i].Currency,
+			OccurredAt:  occurredAtMicros(body[i].OccurredAt),
+			ExternalRef: body[i].ExternalRef,
+			Metadata:    metadata[i],
 		})
 		if err != nil {
 			s.log.ErrorContext(c.Context(), "Failed to marshal transaction payload", slog.Any("error", err))
@@ -39,24 +87,23 @@ func (s *Server) handleJSON(c fiber.Ctx) error {
 			})
 		}
 
-		records[i] = &kgo.Record{
-			Topic: "transactions",
-			Value: payload,
-			Key:   body[i].AccountID[:],
-		}
+		records = append(records, &kgo.Record{
+			Topic:   "transactions",
+			Value:   payload,
+			Key:     body[i].AccountID[:],
+			Headers: headers,
+		})
 	}
 
 	kafkaStart := time.Now()
-	if err := s.client.ProduceSync(c.Context(), records...).FirstErr(); err != nil {
+	if err := s.producer.Produce(c.Context(), records...); err != nil {
 		s.log.ErrorContext(c.Context(), "Failed to sync", slog.Any("error", err))
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Message: "Failed to sync transactions",
 		})
 	}
 	kafkaProducerLatency.Observe(time.Since(kafkaStart).Seconds())
-	kafkaTransactionsProduced.Add(float64(len(body)))
+	kafkaTransactionsProduced.Add(float64(len(records)))
 
-	return c.Status(201).JSON(CreateTransactionResponse{
-		CreatedCount: len(body),
-	})
+	return s.respondProduced(c, batchID, len(records), batchReceipts(records), wait, validator)
 }
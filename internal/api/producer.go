@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/storage"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+/*
+** Spreads produce calls over several Kafka clients so batching, compression and network writes for a large request
+** run on more than one core. A record's client is picked from its partition, so every account, and every
+** partition, is always produced by the same client in the order the handler gave them. Records are produced
+** asynchronously and the acknowledgements are counted down in the clients' callbacks, the handler only blocks
+** until the last one arrives. A transactional producer instead gives each call a client of its own, see
+** NewTransactionalProducer.
+ */
+type Producer struct {
+	clients []*kgo.Client
+	labels  []string // Metric label for each client
+	batches sync.Pool
+	idle    chan int    // Clients without an open transaction, nil unless the producer is transactional
+	opts    [][]kgo.Opt // Each transactional client's options, a client that cannot abort is recreated from them
+}
+
+func NewProducer(clients ...*kgo.Client) *Producer {
+	p := &Producer{
+		clients: clients,
+		labels:  make([]string, len(clients)),
+	}
+	for i := range clients {
+		p.labels[i] = strconv.Itoa(i)
+	}
+	p.batches.New = func() any {
+		return p.newProduceBatch()
+	}
+	return p
+}
+
+/*
+** Makes every Produce call a Kafka transaction. Each client must have been created with its own
+** kgo.TransactionalID, and a call holds one client until its transaction ends. A client whose transaction could
+** not be aborted is closed and replaced by a new one with the same options before it is used again.
+ */
+func NewTransactionalProducer(clients ...*kgo.Client) *Producer {
+	p := NewProducer(clients...)
+	p.idle = make(chan int, len(clients))
+	p.opts = make([][]kgo.Opt, len(clients))
+	for i, cl := range clients {
+		p.opts[i] = cl.Opts()
+		p.idle <- i
+	}
+	return p
+}
+
+// Closes the producer's clients, including any that replaced a client given to NewTransactionalProducer
+func (p *Producer) Close() {
+	if p.idle == nil {
+		for _, cl := range p.clients {
+			cl.Close()
+		}
+		return
+	}
+	// Waits for each client's transaction to end
+	for range p.clients {
+		if cl := p.clients[<-p.idle]; cl != nil {
+			cl.Close()
+		}
+	}
+}
+
+// Tracks the acknowledgements of one Produce call. The callbacks are built once per pooled batch rather than per
+// call, so producing a batch does not allocate closures.
+type produceBatch struct {
+	wg       sync.WaitGroup
+	start    time.Time
+	route    []int          // Client of each record
+	pending  []atomic.Int64 // Unacknowledged records per client
+	promises []func(*kgo.Record, error)
+	mu       sync.Mutex
+	err      error
+}
+
+func (p *Producer) newProduceBatch() *produceBatch {
+	b := &produceBatch{
+		pending:  make([]atomic.Int64, len(p.clients)),
+		promises: make([]func(*kgo.Record, error), len(p.clients)),
+	}
+	for i := range p.clients {
+		label := p.labels[i]
+		b.promises[i] = func(_ *kgo.Record, err error) {
+			if err != nil {
+				b.mu.Lock()
+				if b.err == nil {
+					b.err = err
+				}
+				b.mu.Unlock()
+			}
+			if b.pending[i].Add(-1) == 0 {
+				kafkaClientProduceLatency.WithLabelValues(label).Observe(time.Since(b.start).Seconds())
+			}
+			b.wg.Done()
+		}
+	}
+	return b
+}
+
+/*
+** Produces records and waits for every one to be acknowledged, returning the first error. Like ProduceSync the
+** records have their partition and offset set on return, which the receipts are built from. A transactional
+** producer commits the records as one Kafka transaction, so a failure leaves none of them visible to consumers.
+ */
+func (p *Producer) Produce(ctx context.Context, records ...*kgo.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if p.idle != nil {
+		return p.produceTransaction(ctx, records)
+	}
+
+	b := p.batches.Get().(*produceBatch)
+	defer p.batches.Put(b)
+
+	b.route = b.route[:0]
+	for _, r := range records {
+		b.route = append(b.route, p.clientFor(r.Key))
+	}
+	p.produceRouted(ctx, b, records)
+	return b.err
+}
+
+/*
+** Takes an idle client for the whole batch, since a client holds one transaction at a time. Records of one request
+** stay in order on that client. Ending the transaction is not tied to the request's context, cancelling it part way
+** would leave the client unable to tell whether the commit happened.
+ */
+func (p *Producer) produceTransaction(ctx context.Context, records []*kgo.Record) error {
+	var client int
+	select {
+	case client = <-p.idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		p.idle <- client
+	}()
+	cl, err := p.transactionalClient(client)
+	if err != nil {
+		return err
+	}
+
+	if err := cl.BeginTransaction(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	b := p.batches.Get().(*produceBatch)
+	defer p.batches.Put(b)
+
+	b.route = b.route[:0]
+	for range records {
+		b.route = append(b.route, client)
+	}
+	p.produceRouted(ctx, b, records)
+
+	endCtx := context.WithoutCancel(ctx)
+	if b.err != nil {
+		if err := cl.EndTransaction(endCtx, kgo.TryAbort); err != nil {
+			p.discard(client)
+			return fmt.Errorf("failed to abort transaction after %v: %v", b.err, err)
+		}
+		kafkaTransactionsAborted.Inc()
+		return b.err
+	}
+
+	err = cl.EndTransaction(endCtx, kgo.TryCommit)
+	if errors.Is(err, kerr.OperationNotAttempted) || errors.Is(err, kerr.TransactionAbortable) {
+		// The commit was refused without being applied, abort so the client can begin the next transaction
+		if abortErr := cl.EndTransaction(endCtx, kgo.TryAbort); abortErr != nil {
+			p.discard(client)
+			return fmt.Errorf("failed to abort transaction after %v: %v", err, abortErr)
+		}
+		kafkaTransactionsAborted.Inc()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// Returns a taken client, creating it again if the last transaction on it could not be aborted
+func (p *Producer) transactionalClient(client int) (*kgo.Client, error) {
+	if cl := p.clients[client]; cl != nil {
+		return cl, nil
+	}
+	cl, err := kgo.NewClient(p.opts[client]...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recreate producer client: %v", err)
+	}
+	p.clients[client] = cl
+	return cl, nil
+}
+
+/*
+** Closes a taken client whose transaction could not be aborted. It may still hold that transaction open, so the
+** next call to take it gets a new client instead. The new client registers the same transactional ID, which has
+** the broker abort whatever the old one left open.
+ */
+func (p *Producer) discard(client int) {
+	p.clients[client].Close()
+	p.clients[client] = nil
+}
+
+// Produces each record on the client in b.route and waits for the callbacks to acknowledge all of them
+func (p *Producer) produceRouted(ctx context.Context, b *produceBatch, records []*kgo.Record) {
+	b.start = time.Now()
+	b.err = nil
+
+	// Count every client's share before producing, so a fast client cannot reach zero while its records are still
+	// being handed over
+	for _, client := range b.route {
+		b.pending[client].Add(1)
+	}
+
+	b.wg.Add(len(records))
+	for i, r := range records {
+		client := b.route[i]
+		p.clients[client].Produce(ctx, r, b.promises[client])
+	}
+	b.wg.Wait()
+}
+
+func (p *Producer) clientFor(key []byte) int {
+	if len(p.clients) == 1 {
+		return 0
+	}
+	return int(storage.PartitionForKey(key)) % len(p.clients)
+}
@@ -21,17 +21,55 @@ var (
 		Buckets: prometheus.DefBuckets,
 	}, []string{"path", "method", "status"})
 
+	grpcRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_requests_total",
+		Help: "Total number of gRPC requests",
+	}, []string{"method", "code"})
+
+	grpcRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_request_duration_seconds",
+		Help:    "Duration of gRPC requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
 	kafkaProducerLatency = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "kafka_produce_latency_seconds",
 		Help:    "Latency of producing messages to Kafka",
 		Buckets: prometheus.DefBuckets,
 	})
 
+	kafkaClientProduceLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_client_produce_latency_seconds",
+		Help:    "Latency of each producer client acknowledging its share of a batch",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"client"})
+
+	kafkaTransactionsAborted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_transactions_aborted_total",
+		Help: "Total number of produce transactions aborted, none of whose records reach consumers",
+	})
+
 	kafkaTransactionsProduced = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "kafka_messages_produced_total",
 		Help: "Total number of messages produced to Kafka",
 	})
 
+	idempotentReplays = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idempotent_replays_total",
+		Help: "Total number of requests answered from a stored Idempotency-Key response",
+	})
+
+	commitWaitLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "commit_wait_latency_seconds",
+		Help:    "Time ?wait=committed requests spent waiting for workers to commit their records",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0},
+	})
+
+	commitWaitTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "commit_wait_timeouts_total",
+		Help: "Total number of ?wait=committed requests answered before every partition committed",
+	})
+
 	unmarshalLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "unmarshal_latency_seconds",
 		Help:    "Latency of unmarshalling data",
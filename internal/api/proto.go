@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"time"
 
@@ -9,28 +12,61 @@ import (
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+const (
+	maxRequestBodySize = 100000 * 128
+	maxProtoBatchSize  = 10000 // Records in a pooled RecordBatch slab
+)
+
+var (
+	// Returned when Kafka did not acknowledge every record of a batch, some of them may still have been produced
+	errProduceFailed = errors.New("failed to produce batch")
+	// Returned in strict validation mode when any record is invalid, the validator holds the details
+	errInvalidRecords = errors.New("batch has invalid records")
+)
+
+// The client sent a batch that can never be produced, any other error producing a batch is internal
+type invalidBatchError struct {
+	message string
+}
+
+func (e *invalidBatchError) Error() string {
+	return e.message
+}
+
 /*
 ** Efficient Protobuf handler
  */
 func (s *Server) handleProto(c fiber.Ctx) error {
 	batch := txBatchPool.Get().(*pb.TransactionBatch)
-	rbPtr := recordsPool.Get().(*RecordBatch)
-
-	var count int
-	tempRecordBatch := false
-
 	defer func() {
 		// Clean up and return resources if not oversized
 		batch.Transactions = batch.Transactions[:0]
 		txBatchPool.Put(batch)
-
-		if !tempRecordBatch {
-			rbPtr.Reset(count)
-			recordsPool.Put(rbPtr)
-		}
 	}()
 
-	if len(c.Body()) > 100000*128 {
+	wait, msg := parseCommitWait(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: msg,
+		})
+	}
+
+	mode, msg := s.parseValidationMode(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: msg,
+		})
+	}
+
+	batchID, headers, err := newBatch()
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to generate batch ID", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to process transactions",
+		})
+	}
+
+	if len(c.Body()) > maxRequestBodySize {
 		s.log.ErrorContext(c.Context(), "Request body too large", slog.Int("size", len(c.Body())))
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Message: "Request body too large",
@@ -46,68 +82,96 @@ func (s *Server) handleProto(c fiber.Ctx) error {
 	}
 	unmarshalLatency.WithLabelValues("protobuf").Observe(time.Since(unmarhshalStart).Seconds())
 
-	now := time.Now()
-	body := batch.Transactions
-	count = len(body)
-	var records []*kgo.Record
-	if count > 10000 {
-		// Handle case where batch size exceeds preallocated pool size
-		if count > 10000 {
-			s.log.ErrorContext(c.Context(), "Request batch size too large", slog.Int("count", count))
+	validator := newBatchValidator(mode, len(batch.Transactions))
+	receipts, produced, err := s.produceProtoBatch(c.Context(), batch.Transactions, headers, validator)
+	if err != nil {
+		var invalid *invalidBatchError
+		switch {
+		case errors.Is(err, errInvalidRecords):
+			return validator.respondInvalid(c)
+		case errors.As(err, &invalid):
 			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-				Message: "Request batch size too large",
+				Message: invalid.message,
+			})
+		case errors.Is(err, errProduceFailed):
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Message: "Failed to sync transactions",
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Message: "Failed to process transactions",
 			})
-		}
-		s.log.WarnContext(c.Context(), "Request batch size exceeds pool size, allocating temporary buffers", slog.Int("count", count))
-		tempRecordBatch = true
-		rbPtr = &RecordBatch{
-			Slab:     make([]kgo.Record, count),
-			Pointers: make([]*kgo.Record, count),
-			ByteSlab: make([]byte, count*128), // Allocate byte slab with a safety margin
-			offset:   0,
-		}
-		for i := range rbPtr.Slab {
-			rbPtr.Pointers[i] = &rbPtr.Slab[i]
 		}
 	}
-	records = rbPtr.Pointers[:count] // Slice pointers to the number of records
 
-	s.log.DebugContext(c.Context(), "Creating transaction batch", slog.Int("count", count))
+	return s.respondProduced(c, batchID, produced, receipts, wait, validator)
+}
 
+/*
+** Validates and produces a batch of protobuf transactions, shared by POST /transactions/proto and the gRPC service.
+** Payloads are marshalled into a pooled slab, so only the receipts outlive the call. Returns the receipts and how
+** many records were produced, which is fewer than sent when the validator is in partial mode.
+ */
+func (s *Server) produceProtoBatch(ctx context.Context, body []*pb.Transaction, headers []kgo.RecordHeader, validator *batchValidator) ([]Receipt, int, error) {
+	count := len(body)
+	if count > maxProtoBatchSize {
+		s.log.ErrorContext(ctx, "Request batch size too large", slog.Int("count", count))
+		return nil, 0, &invalidBatchError{message: "Request batch size too large"}
+	}
+
+	for i, tx := range body {
+		validator.check(i, tx.Id, tx.AccountId, tx.Amount, tx.Currency, tx.ExternalRef, tx.Metadata)
+	}
+	if validator.rejectsBatch() {
+		return nil, 0, errInvalidRecords
+	}
+
+	rbPtr := recordsPool.Get().(*RecordBatch)
+	defer func() {
+		rbPtr.Reset(count)
+		recordsPool.Put(rbPtr)
+	}()
+
+	now := time.Now()
+	records := rbPtr.Pointers[:count] // Slice pointers to the number of records
+
+	s.log.DebugContext(ctx, "Creating transaction batch", slog.Int("count", count))
+
+	produced := 0
 	for i := range count {
+		if validator.skipped(i) {
+			continue
+		}
+
 		size := body[i].SizeVT()
 		payloadBuf, err := rbPtr.NextRecord(size)
 		if err != nil {
-			s.log.ErrorContext(c.Context(), "Failed to allocate payload buffer", slog.Any("error", err))
-			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-				Message: "Failed to process transactions",
-			})
+			s.log.ErrorContext(ctx, "Failed to allocate payload buffer", slog.Any("error", err))
+			return nil, 0, err
 		}
 
 		if _, err := body[i].MarshalToSizedBufferVT(payloadBuf); err != nil {
-			s.log.ErrorContext(c.Context(), "Failed to marshal transaction payload", slog.Any("error", err))
-			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-				Message: "Failed to process transactions",
-			})
+			s.log.ErrorContext(ctx, "Failed to marshal transaction payload", slog.Any("error", err))
+			return nil, 0, err
 		}
 
-		records[i].Topic = "transactions"
-		records[i].Value = payloadBuf
-		records[i].Key = body[i].AccountId[:]
-		records[i].Timestamp = now
+		record := records[produced]
+		record.Topic = "transactions"
+		record.Value = payloadBuf
+		record.Key = body[i].AccountId[:]
+		record.Timestamp = now
+		record.Headers = headers
+		produced++
 	}
+	records = records[:produced]
 
 	kafkaStart := time.Now()
-	if err := s.client.ProduceSync(c.Context(), records...).FirstErr(); err != nil {
-		s.log.ErrorContext(c.Context(), "Failed to sync", slog.Any("error", err))
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Message: "Failed to sync transactions",
-		})
+	if err := s.producer.Produce(ctx, records...); err != nil {
+		s.log.ErrorContext(ctx, "Failed to sync", slog.Any("error", err))
+		return nil, 0, fmt.Errorf("%w: %v", errProduceFailed, err)
 	}
 	kafkaProducerLatency.Observe(time.Since(kafkaStart).Seconds())
-	kafkaTransactionsProduced.Add(float64(count))
+	kafkaTransactionsProduced.Add(float64(produced))
 
-	return c.Status(201).JSON(CreateTransactionResponse{
-		CreatedCount: count,
-	})
+	return batchReceipts(records), produced, nil
 }
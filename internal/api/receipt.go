@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/storage"
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	defaultCommitWait  = 5 * time.Second
+	maxCommitWait      = 30 * time.Second
+	commitPollInterval = 25 * time.Millisecond
+	maxCommitPoll      = 250 * time.Millisecond
+)
+
+var errInvalidReceipt = errors.New("invalid receipt")
+
+/*
+** Receipt tokens are opaque to clients: base64url of the partition followed by the offset it must commit past
+ */
+func encodeReceipt(partition int32, offset int64) string {
+	buf := make([]byte, 0, 12)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(partition))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(offset))
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeReceipt(token string) (int32, int64, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != 12 {
+		return 0, 0, errInvalidReceipt
+	}
+
+	partition := int32(binary.BigEndian.Uint32(buf[:4]))
+	offset := int64(binary.BigEndian.Uint64(buf[4:]))
+	if partition < 0 || partition >= storage.NumPartitions || offset < 0 {
+		return 0, 0, errInvalidReceipt
+	}
+	return partition, offset, nil
+}
+
+/*
+** Parses ?wait=committed and its optional ?timeout, a Go duration such as 2s. Returns a zero timeout when the caller
+** does not want to wait, or a message when the parameters are invalid.
+ */
+func parseCommitWait(c fiber.Ctx) (time.Duration, string) {
+	switch c.Query("wait") {
+	case "":
+		return 0, ""
+	case "committed":
+	default:
+		return 0, "Invalid wait, must be committed"
+	}
+
+	timeout := defaultCommitWait
+	if timeoutStr := c.Query("timeout"); timeoutStr != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 || timeout > maxCommitWait {
+			return 0, "Invalid timeout, must be a duration between 0s and " + maxCommitWait.String()
+		}
+	}
+	return timeout, ""
+}
+
+// Highest produced offset per partition, records must have been produced so their partition and offset are set
+func batchReceipts(records []*kgo.Record) []Receipt {
+	var highest [storage.NumPartitions]int64
+	for i := range highest {
+		highest[i] = -1
+	}
+	for _, r := range records {
+		highest[r.Partition] = max(highest[r.Partition], r.Offset)
+	}
+
+	var receipts []Receipt
+	for partition, offset := range highest {
+		if offset >= 0 {
+			receipts = append(receipts, Receipt{
+				Partition: int32(partition),
+				Offset:    offset,
+				Token:     encodeReceipt(int32(partition), offset),
+			})
+		}
+	}
+	return receipts
+}
+
+/*
+** Polls kafka_offsets until every partition's worker has committed past its receipt or the timeout expires. Reports
+** false on timeout, the records are still durable in Kafka and will be committed later.
+ */
+func (s *Server) waitForCommit(ctx context.Context, receipts []Receipt, timeout time.Duration) (bool, error) {
+	start := time.Now()
+	defer func() {
+		commitWaitLatency.Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := slices.Clone(receipts)
+	partitions := make([]int32, 0, len(pending))
+	interval := commitPollInterval
+	for {
+		partitions = partitions[:0]
+		for _, r := range pending {
+			partitions = append(partitions, r.Partition)
+		}
+
+		committed, err := s.store.CommittedOffsets(ctx, partitions)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+			return false, err
+		}
+		pending = slices.DeleteFunc(pending, func(r Receipt) bool {
+			offset, ok := committed[r.Partition]
+			return ok && offset >= r.Offset
+		})
+		if len(pending) == 0 {
+			return true, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			commitWaitTimeouts.Inc()
+			return false, nil
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxCommitPoll)
+	}
+}
+
+/*
+** Answers an ingestion request once its records are produced, recording the batch so GET /batches/:id can report
+** on it. Without a wait the response is 201 as before. When the
+** caller asked to wait the response is 201 once everything is committed, or 202 with the receipts to poll if the
+** timeout expired first.
+ */
+func (s *Server) respondProduced(c fiber.Ctx, batchID uuid.UUID, count int, receipts []Receipt, wait time.Duration, validator *batchValidator) error {
+	s.recordBatch(c.Context(), batchID, count)
+
+	resp := CreateTransactionResponse{
+		BatchID:      batchID,
+		CreatedCount: count,
+		Receipts:     receipts,
+		InvalidCount: validator.invalid,
+		Errors:       validator.report(),
+	}
+	if wait == 0 {
+		return c.Status(fiber.StatusCreated).JSON(resp)
+	}
+
+	committed, err := s.waitForCommit(c.Context(), resp.Receipts, wait)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to check committed offsets", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to check committed offsets",
+		})
+	}
+	resp.Committed = committed
+	if !committed {
+		return c.Status(fiber.StatusAccepted).JSON(resp)
+	}
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// The records are already in Kafka and will be applied, failing the request here would only invite a retry
+func (s *Server) recordBatch(ctx context.Context, batchID uuid.UUID, count int) {
+	if err := s.store.CreateBatch(ctx, batchID, count); err != nil {
+		s.log.ErrorContext(ctx, "Failed to record batch", slog.String("batch_id", batchID.String()), slog.Any("error", err))
+	}
+}
+
+/*
+** Combines the receipts of batches produced under one batch ID, keeping the highest offset for each partition
+ */
+func mergeReceipts(receipts []Receipt, more []Receipt) []Receipt {
+	for _, r := range more {
+		i := slices.IndexFunc(receipts, func(existing Receipt) bool {
+			return existing.Partition == r.Partition
+		})
+		switch {
+		case i < 0:
+			receipts = append(receipts, r)
+		case r.Offset > receipts[i].Offset:
+			receipts[i] = r
+		}
+	}
+	slices.SortFunc(receipts, func(a, b Receipt) int {
+		return int(a.Partition - b.Partition)
+	})
+	return receipts
+}
+
+func (s *Server) handleGetReceipt(c fiber.Ctx) error {
+	token := c.Params("token")
+	partition, offset, err := decodeReceipt(token)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid receipt token",
+		})
+	}
+
+	committed, err := s.store.CommittedOffsets(c.Context(), []int32{partition})
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to check committed offsets", slog.Int("partition", int(partition)), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to check receipt",
+		})
+	}
+
+	committedOffset, ok := committed[partition]
+	if !ok {
+		committedOffset = -1
+	}
+	return c.JSON(ReceiptResponse{
+		Partition:       partition,
+		Offset:          offset,
+		CommittedOffset: committedOffset,
+		Committed:       committedOffset >= offset,
+	})
+}
@@ -18,6 +18,7 @@ func (r *RecordBatch) Reset(count int) {
 	for i := range count {
 		r.Slab[i].Value = nil
 		r.Slab[i].Key = nil
+		r.Slab[i].Headers = nil
 	}
 }
 
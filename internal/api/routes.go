@@ -1,17 +1,63 @@
 package api
 
 import (
+	"io"
+
 	"github.com/gofiber/fiber/v3"
 )
 
 func (s *Server) registerRoutes() {
 	s.app.Get("/health", s.handleHealth)
+	s.app.Get("/accounts", s.handleListAccounts)
 	s.app.Get("/accounts/:id", s.handleGetAccount)
+	s.app.Get("/accounts/:id/transactions", s.handleListAccountTransactions)
+	s.app.Get("/transactions", s.handleFindTransactions)
 	s.app.Get("/transactions/:id", s.handleGetTransaction)
+	s.app.Get("/transfers/:id", s.handleGetTransfer)
+	s.app.Get("/batches/:id", s.handleGetBatch)
+	s.app.Get("/receipts/:token", s.handleGetReceipt)
+
+	s.app.Post("/accounts", bodyLimitMiddleware, s.handleCreateAccount)
+	s.app.Patch("/accounts/:id", bodyLimitMiddleware, s.handleUpdateAccount)
+	s.app.Post("/transactions/json", bodyLimitMiddleware, s.idempotencyMiddleware, s.handleJSON)
+	s.app.Post("/transactions/effjson", bodyLimitMiddleware, s.idempotencyMiddleware, s.handleEfficientJSON)
+	s.app.Post("/transactions/proto", bodyLimitMiddleware, s.idempotencyMiddleware, s.handleProto)
+	s.app.Post("/transactions/stream", s.handleStream)
+	s.app.Post("/transfers", bodyLimitMiddleware, s.idempotencyMiddleware, s.handleCreateTransfer)
+}
+
+/*
+** Rejects bodies over the limit before they are buffered. The server streams large bodies instead of rejecting
+** them, so without this c.Body() would read a body of any size into memory. A chunked body declares no length, so
+** a streamed body is read through a limit and replaced by what was read, which c.Body() then returns.
+ */
+func bodyLimitMiddleware(c fiber.Ctx) error {
+	req := c.Request()
+	if req.Header.ContentLength() > maxRequestBodySize {
+		return bodyTooLarge(c)
+	}
+
+	if req.IsBodyStream() {
+		body, err := io.ReadAll(io.LimitReader(req.BodyStream(), maxRequestBodySize+1))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Failed to read request body",
+			})
+		}
+		if len(body) > maxRequestBodySize {
+			return bodyTooLarge(c)
+		}
+		req.SetBody(body)
+	}
+	return c.Next()
+}
 
-	s.app.Post("/transactions/json", s.handleJSON)
-	s.app.Post("/transactions/effjson", s.handleEfficientJSON)
-	s.app.Post("/transactions/proto", s.handleProto)
+// The rest of the body is never read, so the connection cannot be reused
+func bodyTooLarge(c fiber.Ctx) error {
+	c.Response().SetConnectionClose()
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(ErrorResponse{
+		Message: "Request body too large",
+	})
 }
 
 func (s *Server) handleHealth(c fiber.Ctx) error {
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Hides the body's length from the request so it is sent chunked
+type chunkedReader struct {
+	io.Reader
+}
+
+func TestBodyLimit(t *testing.T) {
+	s := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil)
+	s.app.Post("/test/body", bodyLimitMiddleware, func(c fiber.Ctx) error {
+		return c.SendString(strconv.Itoa(len(c.Body())))
+	})
+
+	tests := []struct {
+		name       string
+		size       int
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "under the limit", size: 1024, wantStatus: fiber.StatusOK},
+		{name: "over the limit", size: maxRequestBodySize + 1, wantStatus: fiber.StatusRequestEntityTooLarge},
+		{name: "chunked under the limit", size: 1024, chunked: true, wantStatus: fiber.StatusOK},
+		{name: "chunked at the limit", size: maxRequestBodySize, chunked: true, wantStatus: fiber.StatusOK},
+		{name: "chunked over the limit", size: maxRequestBodySize + 1, chunked: true, wantStatus: fiber.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(make([]byte, tt.size))
+			if tt.chunked {
+				body = chunkedReader{body}
+			}
+			req := httptest.NewRequest(http.MethodPost, "/test/body", body)
+			if tt.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+
+			resp, err := s.app.Test(req, fiber.TestConfig{Timeout: 0})
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == fiber.StatusOK {
+				got, _ := io.ReadAll(resp.Body)
+				if string(got) != strconv.Itoa(tt.size) {
+					t.Errorf("handler read %s bytes, want %d", got, tt.size)
+				}
+			}
+		})
+	}
+}
@@ -2,44 +2,96 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/adaptor"
 	"github.com/gofiber/fiber/v3/middleware/pprof"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
-	log    *slog.Logger
-	app    *fiber.App
-	store  StoreRegistry
-	client *kgo.Client
+	log      *slog.Logger
+	app      *fiber.App
+	grpc     *grpc.Server
+	store    StoreRegistry
+	producer *Producer
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	validationMode string // Used when a request does not choose one
 }
 
-func NewServer(log *slog.Logger, store StoreRegistry, client *kgo.Client) *Server {
-	app := fiber.New()
+func NewServer(log *slog.Logger, store StoreRegistry, producer *Producer) *Server {
+	// Bodies over the limit are streamed to the handler rather than rejected, so POST /transactions/stream can take
+	// unbounded batches. Every other route caps the body with bodyLimitMiddleware.
+	app := fiber.New(fiber.Config{
+		BodyLimit:         maxRequestBodySize,
+		StreamRequestBody: true,
+	})
 	app.Use(pprof.New())
 
 	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 	app.Use(prometheusMiddleware)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Server{
-		log:    log,
-		app:    app,
-		store:  store,
-		client: client,
+		log:      log,
+		app:      app,
+		store:    store,
+		producer: producer,
+		ctx:      ctx,
+		cancel:   cancel,
+
+		validationMode: validationStrict,
 	}
 
 	s.registerRoutes()
+	s.grpc = newGRPCServer(s)
 	return s
 }
 
+// Sets the validation mode for requests that do not pass ?validation=, either strict or partial
+func (s *Server) SetValidationMode(mode string) error {
+	if mode != validationStrict && mode != validationPartial {
+		return fmt.Errorf("invalid validation mode: %v", mode)
+	}
+	s.validationMode = mode
+	return nil
+}
+
 func (s *Server) Run() error {
+	lis, err := net.Listen("tcp", ":9090")
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		if err := s.grpc.Serve(lis); err != nil {
+			s.log.Error("gRPC server stopped", slog.Any("error", err))
+		}
+	}()
+
+	go s.purgeIdempotencyKeys(s.ctx)
 	return s.app.Listen(":8080")
 }
 
 func (s *Server) Stop(ctx context.Context) error {
+	s.cancel()
+
+	// Let in-flight streams finish producing, but not past the shutdown deadline
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+
 	return s.app.ShutdownWithContext(ctx)
 }
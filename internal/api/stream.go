@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/bytedance/sonic"
+	"github.com/gofiber/fiber/v3"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const maxStreamLineSize = 64 * 1024 // Longest NDJSON line or protobuf message accepted
+
+/*
+** Streaming ingestion for batches too large for a single request body, such as nightly settlement files. The body
+** is newline-delimited JSON, or varint length-prefixed protobuf Transaction messages when sent as
+** application/x-protobuf. Records are produced a slab at a time as they are read, so memory use does not grow with
+** the body. Lines that fail to parse or validate are skipped and reported in the summary, the rest are produced.
+** Duplicate IDs are not tracked across a stream of unbounded length, the merge still records each ID once.
+** The route takes no Idempotency-Key, hashing the body would buffer it, and retried records are deduplicated by ID.
+ */
+func (s *Server) handleStream(c fiber.Ctx) error {
+	wait, msg := parseCommitWait(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: msg,
+		})
+	}
+
+	batchID, headers, err := newBatch()
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to generate batch ID", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to process transactions",
+		})
+	}
+
+	body := c.Request().BodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	p := &streamProducer{
+		s:       s,
+		c:       c,
+		headers: headers,
+		rb:      recordsPool.Get().(*RecordBatch),
+		resp:    StreamTransactionsResponse{BatchID: batchID},
+	}
+	defer func() {
+		p.rb.Reset(p.count)
+		recordsPool.Put(p.rb)
+	}()
+
+	var readErr error
+	if strings.HasPrefix(string(c.Request().Header.ContentType()), "application/x-protobuf") {
+		readErr = p.readProtobuf(bufio.NewReader(body))
+	} else {
+		readErr = p.readNDJSON(body)
+	}
+	if readErr == nil {
+		readErr = p.flush()
+	}
+	s.recordBatch(c.Context(), batchID, p.resp.Produced)
+
+	switch {
+	case errors.Is(readErr, errProduceFailed):
+		p.resp.Message = fmt.Sprintf("Failed to sync transactions, %d produced before the failure", p.resp.Produced)
+		return c.Status(fiber.StatusInternalServerError).JSON(p.resp)
+	case readErr != nil:
+		p.resp.Message = "Invalid request body, " + readErr.Error()
+		return c.Status(fiber.StatusBadRequest).JSON(p.resp)
+	}
+
+	if wait == 0 {
+		return c.Status(fiber.StatusCreated).JSON(p.resp)
+	}
+	committed, err := s.waitForCommit(c.Context(), p.resp.Receipts, wait)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to check committed offsets", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to check committed offsets",
+		})
+	}
+	p.resp.Committed = committed
+	if !committed {
+		return c.Status(fiber.StatusAccepted).JSON(p.resp)
+	}
+	return c.Status(fiber.StatusCreated).JSON(p.resp)
+}
+
+// Fills a pooled RecordBatch slab from the stream and produces it whenever it runs out of records or bytes
+type streamProducer struct {
+	s       *Server
+	c       fiber.Ctx
+	headers []kgo.RecordHeader
+	rb      *RecordBatch
+	count   int // Records in the slab waiting to be produced
+	resp    StreamTransactionsResponse
+}
+
+func (p *streamProducer) readNDJSON(body io.Reader) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxStreamLineSize)
+
+	var line int
+	var req TransactionRequest
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+
+		req = TransactionRequest{}
+		if err := sonic.Unmarshal(raw, &req); err != nil {
+			p.lineError(line, recordMalformed, "Invalid JSON for a transaction")
+			continue
+		}
+
+		metadata := metadataBytes(req.Metadata)
+		if reason, msg := validateTransaction(req.ID[:], req.AccountID[:], req.Amount, req.Currency, req.ExternalRef, metadata); reason != "" {
+			p.lineError(line, reason, msg)
+			continue
+		}
+
+		tx := pb.Transaction{
+			Id:          req.ID[:],
+			AccountId:   req.AccountID[:],
+			Amount:      req.Amount,
+			Currency:    req.Currency,
+			OccurredAt:  occurredAtMicros(req.OccurredAt),
+			ExternalRef: req.ExternalRef,
+			Metadata:    metadata,
+		}
+		if err := p.add(&tx); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream aborted after line %d: %v", line, err)
+	}
+	return nil
+}
+
+func (p *streamProducer) readProtobuf(body *bufio.Reader) error {
+	buf := make([]byte, maxStreamLineSize)
+
+	var line int
+	var tx pb.Transaction
+	for {
+		size, err := binary.ReadUvarint(body)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		line++
+		if err != nil {
+			return fmt.Errorf("stream aborted at message %d: %v", line, err)
+		}
+		if size > maxStreamLineSize {
+			return fmt.Errorf("stream aborted at message %d: message larger than %d bytes", line, maxStreamLineSize)
+		}
+		if _, err := io.ReadFull(body, buf[:size]); err != nil {
+			return fmt.Errorf("stream aborted at message %d: %v", line, err)
+		}
+
+		// UnmarshalVT only sets the fields present on the wire, clear the previous message's values first
+		tx = pb.Transaction{}
+		if err := tx.UnmarshalVT(buf[:size]); err != nil {
+			p.lineError(line, recordMalformed, "Invalid protobuf message")
+			continue
+		}
+		if reason, msg := validateTransaction(tx.Id, tx.AccountId, tx.Amount, tx.Currency, tx.ExternalRef, tx.Metadata); reason != "" {
+			p.lineError(line, reason, msg)
+			continue
+		}
+
+		if err := p.add(&tx); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+** Marshals a transaction into the slab. Its key is copied into the slab as well, since the decoded transaction is
+** reused for the next line before the slab is produced.
+ */
+func (p *streamProducer) add(tx *pb.Transaction) error {
+	size := tx.SizeVT()
+	if p.count == len(p.rb.Pointers) || p.rb.offset+size+len(tx.AccountId) > len(p.rb.ByteSlab) {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+
+	key, err := p.rb.NextRecord(len(tx.AccountId))
+	if err != nil {
+		return err
+	}
+	copy(key, tx.AccountId)
+
+	payloadBuf, err := p.rb.NextRecord(size)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.MarshalToSizedBufferVT(payloadBuf); err != nil {
+		p.s.log.ErrorContext(p.c.Context(), "Failed to marshal transaction payload", slog.Any("error", err))
+		return err
+	}
+
+	record := p.rb.Pointers[p.count]
+	record.Topic = "transactions"
+	record.Value = payloadBuf
+	record.Key = key
+	record.Timestamp = time.Now()
+	record.Headers = p.headers
+	p.count++
+	return nil
+}
+
+func (p *streamProducer) flush() error {
+	if p.count == 0 {
+		return nil
+	}
+	records := p.rb.Pointers[:p.count]
+
+	kafkaStart := time.Now()
+	if err := p.s.producer.Produce(p.c.Context(), records...); err != nil {
+		p.s.log.ErrorContext(p.c.Context(), "Failed to sync", slog.Any("error", err))
+		return fmt.Errorf("%w: %v", errProduceFailed, err)
+	}
+	kafkaProducerLatency.Observe(time.Since(kafkaStart).Seconds())
+	kafkaTransactionsProduced.Add(float64(p.count))
+
+	p.resp.Produced += p.count
+	p.resp.Receipts = mergeReceipts(p.resp.Receipts, batchReceipts(records))
+
+	p.rb.Reset(p.count)
+	p.count = 0
+	return nil
+}
+
+func (p *streamProducer) lineError(line int, reason string, message string) {
+	p.resp.Failed++
+	if len(p.resp.Errors) < maxReportedRecordErrors {
+		p.resp.Errors = append(p.resp.Errors, LineError{Line: line, Reason: reason, Message: message})
+	}
+}
@@ -2,11 +2,20 @@ package api
 
 import (
 	"log/slog"
+	"strconv"
+	"time"
 
+	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
 )
 
+const (
+	defaultTransactionPageSize = 100
+	maxTransactionPageSize     = 1000
+	maxExternalRefMatches      = 100
+)
+
 func (s *Server) handleGetTransaction(c fiber.Ctx) error {
 	idStr := c.Params("id")
 	id, err := uuid.Parse(idStr)
@@ -31,10 +40,135 @@ func (s *Server) handleGetTransaction(c fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(TransactionResponse{
-		ID:        transaction.ID,
-		AccountID: transaction.AccountID,
-		Amount:    transaction.Amount,
-		CreatedAt: transaction.CreatedAt,
-	})
+	return c.JSON(newTransactionResponse(transaction))
+}
+
+func (s *Server) handleListAccountTransactions(c fiber.Ctx) error {
+	idStr := c.Params("id")
+	accountID, err := uuid.Parse(idStr)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Invalid account ID format", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid account ID format",
+		})
+	}
+
+	filter := model.TransactionFilter{
+		Limit: defaultTransactionPageSize,
+	}
+
+	if after := c.Query("after"); after != "" {
+		filter.After, err = decodeTransactionCursor(after)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid after cursor",
+			})
+		}
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		filter.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxTransactionPageSize {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid limit, must be between 1 and " + strconv.Itoa(maxTransactionPageSize),
+			})
+		}
+	}
+
+	if from := c.Query("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339Nano, from)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid from, must be an RFC 3339 timestamp",
+			})
+		}
+	}
+
+	if to := c.Query("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339Nano, to)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid to, must be an RFC 3339 timestamp",
+			})
+		}
+	}
+
+	transactions, err := s.store.ListAccountTransactions(c.Context(), accountID, filter)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to list account transactions", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to list account transactions",
+		})
+	}
+
+	resp := ListTransactionsResponse{
+		Transactions: make([]TransactionResponse, len(transactions)),
+	}
+	for i := range transactions {
+		resp.Transactions[i] = newTransactionResponse(&transactions[i])
+	}
+	if len(transactions) == filter.Limit {
+		last := transactions[len(transactions)-1]
+		resp.NextCursor = encodeTransactionCursor(model.TransactionCursor{
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+		})
+	}
+
+	return c.JSON(resp)
+}
+
+/*
+** Finds transactions by the reference an upstream payment processor gave them, for reconciliation. References are
+** not unique, so every match up to the limit is returned, newest first.
+ */
+func (s *Server) handleFindTransactions(c fiber.Ctx) error {
+	ref := c.Query("external_ref")
+	if ref == "" || len(ref) > maxExternalRefLength {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid external_ref, must be between 1 and 255 bytes",
+		})
+	}
+
+	limit := maxExternalRefMatches
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxExternalRefMatches {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Message: "Invalid limit, must be between 1 and " + strconv.Itoa(maxExternalRefMatches),
+			})
+		}
+	}
+
+	transactions, err := s.store.FindTransactionsByExternalRef(c.Context(), ref, limit)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to find transactions", slog.String("external_ref", ref), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to find transactions",
+		})
+	}
+
+	resp := ListTransactionsResponse{
+		Transactions: make([]TransactionResponse, len(transactions)),
+	}
+	for i := range transactions {
+		resp.Transactions[i] = newTransactionResponse(&transactions[i])
+	}
+
+	return c.JSON(resp)
+}
+
+func newTransactionResponse(transaction *model.Transaction) TransactionResponse {
+	return TransactionResponse{
+		ID:          transaction.ID,
+		AccountID:   transaction.AccountID,
+		Amount:      transaction.Amount,
+		CreatedAt:   transaction.CreatedAt,
+		OccurredAt:  transaction.OccurredAt,
+		ExternalRef: transaction.ExternalRef,
+		Metadata:    transaction.Metadata,
+		Stage:       transaction.Stage,
+		Reason:      transaction.Reason,
+	}
 }
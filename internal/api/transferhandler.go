@@ -0,0 +1,145 @@
+package api
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/alexmcook/transaction-ledger/internal/storage"
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Accepted but not yet applied or rejected by the journal processor
+const transferStatusPending = "pending"
+
+/*
+** Submits a double-entry transfer. The entry is produced as a single record, so its legs are applied by the
+** journal processor together or not at all.
+ */
+func (s *Server) handleCreateTransfer(c fiber.Ctx) error {
+	var body TransferRequest
+	if err := c.Bind().JSON(&body); err != nil {
+		s.log.ErrorContext(c.Context(), "Invalid request body", slog.Any("error", err))
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid request body",
+		})
+	}
+
+	id := body.ID
+	if id == uuid.Nil {
+		var err error
+		id, err = uuid.NewV7()
+		if err != nil {
+			s.log.ErrorContext(c.Context(), "Failed to generate transfer ID", slog.Any("error", err))
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Message: "Failed to create transfer",
+			})
+		}
+	}
+
+	if !validCurrencyCode(body.Currency) {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid currency, must be an ISO 4217 code",
+		})
+	}
+
+	legs := make([]model.JournalLeg, len(body.Legs))
+	for i, leg := range body.Legs {
+		legs[i] = model.JournalLeg{AccountID: leg.AccountID, Amount: leg.Amount}
+	}
+	entry := storage.NewJournalEntry(id, body.Currency, legs)
+	if err := storage.ValidateJournalEntry(entry); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid transfer, " + err.Error(),
+		})
+	}
+
+	msg := &pb.JournalEntry{
+		Id:       entry.ID[:],
+		Legs:     make([]*pb.JournalLeg, len(entry.Legs)),
+		Currency: entry.Currency,
+	}
+	for i := range entry.Legs {
+		msg.Legs[i] = &pb.JournalLeg{
+			AccountId: entry.Legs[i].AccountID[:],
+			Amount:    entry.Legs[i].Amount,
+		}
+	}
+	payload, err := msg.MarshalVT()
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to marshal journal entry", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to create transfer",
+		})
+	}
+
+	kafkaStart := time.Now()
+	record := &kgo.Record{
+		Topic: "journal",
+		Value: payload,
+		Key:   entry.ID[:],
+	}
+	if err := s.producer.Produce(c.Context(), record); err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to sync", slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to sync transfer",
+		})
+	}
+	kafkaProducerLatency.Observe(time.Since(kafkaStart).Seconds())
+	kafkaTransactionsProduced.Inc()
+
+	resp := newTransferResponse(entry)
+	resp.Status = transferStatusPending
+	resp.CreatedAt = nil
+	return c.Status(fiber.StatusAccepted).JSON(resp)
+}
+
+func (s *Server) handleGetTransfer(c fiber.Ctx) error {
+	idStr := c.Params("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Invalid transfer ID format", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Message: "Invalid transfer ID format",
+		})
+	}
+
+	entry, err := s.store.GetJournalEntry(c.Context(), id)
+	if err != nil {
+		s.log.ErrorContext(c.Context(), "Failed to retrieve transfer", slog.String("id", idStr), slog.Any("error", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Message: "Failed to retrieve transfer",
+		})
+	}
+
+	// Transfers still in Kafka are indistinguishable from unknown IDs
+	if entry == nil {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Message: "Transfer not found",
+		})
+	}
+
+	return c.JSON(newTransferResponse(entry))
+}
+
+func newTransferResponse(entry *model.JournalEntry) TransferResponse {
+	resp := TransferResponse{
+		ID:        entry.ID,
+		Currency:  entry.Currency,
+		Status:    entry.Status,
+		Reason:    entry.Reason,
+		Legs:      make([]TransferLeg, len(entry.Legs)),
+		CreatedAt: &entry.CreatedAt,
+	}
+	for i, leg := range entry.Legs {
+		resp.Legs[i] = TransferLeg{
+			TransactionID: leg.TransactionID,
+			AccountID:     leg.AccountID,
+			Amount:        leg.Amount,
+		}
+	}
+	return resp
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/alexmcook/transaction-ledger/internal/model"
@@ -12,30 +13,184 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
+// Amounts are integers in the currency's minor unit, the formatted fields render them as decimals
 type AccountResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Balance   int64     `json:"balance"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                      uuid.UUID `json:"id"`
+	Currency                string    `json:"currency"`
+	Balance                 int64     `json:"balance"`
+	PendingBalance          int64     `json:"pending_balance"`
+	CurrentBalance          int64     `json:"current_balance"`
+	FormattedCurrentBalance string    `json:"formatted_current_balance"`
+	MinBalance              int64     `json:"min_balance"`
+	Available               int64     `json:"available_balance"` // Headroom above min_balance for new debits
+	FormattedAvailable      string    `json:"formatted_available_balance"`
+	Partition               int32     `json:"partition"`
+	AsOfOffset              int64     `json:"as_of_offset"`
+	Status                  string    `json:"status"`
+	CreatedAt               time.Time `json:"created_at"`
+	UpdatedAt               time.Time `json:"updated_at"`
+}
+
+type AccountSummary struct {
+	ID               uuid.UUID `json:"id"`
+	Currency         string    `json:"currency"`
+	Balance          int64     `json:"balance"`
+	FormattedBalance string    `json:"formatted_balance"`
+	MinBalance       int64     `json:"min_balance"`
+	Partition        int32     `json:"partition"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type ListAccountsResponse struct {
+	Accounts  []AccountSummary `json:"accounts"`
+	NextAfter *uuid.UUID       `json:"next_after,omitempty"`
+}
+
+type CreateAccountRequest struct {
+	ID         uuid.UUID `json:"id"`          // Optional, a UUIDv7 is generated when omitted
+	Currency   string    `json:"currency"`    // Optional, defaults to USD
+	MinBalance int64     `json:"min_balance"` // Optional, defaults to 0, negative allows an overdraft
+}
+
+// Omitted fields are left unchanged
+type UpdateAccountRequest struct {
+	Status     string `json:"status"`
+	MinBalance *int64 `json:"min_balance"`
 }
 
 type TransactionResponse struct {
-	ID        uuid.UUID `json:"id"`
-	AccountID uuid.UUID `json:"account_id"`
-	Amount    int64     `json:"amount"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          uuid.UUID       `json:"id"`
+	AccountID   uuid.UUID       `json:"account_id"`
+	Amount      int64           `json:"amount"`
+	CreatedAt   time.Time       `json:"created_at"`
+	OccurredAt  *time.Time      `json:"occurred_at,omitempty"`
+	ExternalRef string          `json:"external_ref,omitempty"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	Stage       string          `json:"stage"`
+	Reason      string          `json:"reason,omitempty"`
+}
+
+type ListTransactionsResponse struct {
+	Transactions []TransactionResponse `json:"transactions"`
+	NextCursor   string                `json:"next_cursor,omitempty"`
 }
 
 type CreateTransactionResponse struct {
-	CreatedCount int `json:"created_count"`
+	BatchID      uuid.UUID     `json:"batch_id"`
+	CreatedCount int           `json:"created_count"`
+	Committed    bool          `json:"committed"` // Only true when ?wait=committed saw every partition commit in time
+	Receipts     []Receipt     `json:"receipts"`
+	InvalidCount int           `json:"invalid_count,omitempty"` // Records left out under ?validation=partial
+	Errors       []RecordError `json:"errors,omitempty"`
+}
+
+type RecordError struct {
+	Index   int    `json:"index"` // Position of the record in the request body
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponse struct {
+	Message      string        `json:"message"`
+	InvalidCount int           `json:"invalid_count"`
+	Errors       []RecordError `json:"errors"` // The first 100 invalid records
+}
+
+type StreamTransactionsResponse struct {
+	BatchID   uuid.UUID   `json:"batch_id"`
+	Produced  int         `json:"produced"`
+	Failed    int         `json:"failed"` // Lines skipped because they failed to parse or validate
+	Errors    []LineError `json:"errors,omitempty"`
+	Committed bool        `json:"committed"`
+	Receipts  []Receipt   `json:"receipts"`
+	Message   string      `json:"message,omitempty"` // Why the stream stopped early, records before it were produced
+}
+
+type LineError struct {
+	Line    int    `json:"line"` // 1-based line, or message number for protobuf streams
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Where a batch's records landed in one partition, the token can be polled at GET /receipts/:token
+type Receipt struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"` // Highest offset produced to the partition by the batch
+	Token     string `json:"token"`
+}
+
+type BatchResponse struct {
+	ID            uuid.UUID `json:"id"`
+	Status        string    `json:"status"`
+	Produced      int       `json:"produced"`
+	Staged        int       `json:"staged"`
+	Merged        int       `json:"merged"`
+	Rejected      int       `json:"rejected"`
+	Duplicates    int       `json:"duplicates"`    // Staged but already recorded, by an earlier batch or a redelivery
+	DeadLettered  int       `json:"dead_lettered"` // Sent to the dead-letter topic instead of being staged
+	WrittenBehind int       `json:"written_behind"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type ReceiptResponse struct {
+	Partition       int32 `json:"partition"`
+	Offset          int64 `json:"offset"`
+	CommittedOffset int64 `json:"committed_offset"`
+	Committed       bool  `json:"committed"`
 }
 
 type TransactionRequest struct {
-	ID        uuid.UUID `json:"id"`
+	ID          uuid.UUID       `json:"id"`
+	AccountID   uuid.UUID       `json:"account_id"`
+	Amount      int64           `json:"amount"`
+	Currency    string          `json:"currency"`     // Optional, the amount is applied in the account's currency when omitted
+	OccurredAt  *time.Time      `json:"occurred_at"`  // Optional, when the payment happened upstream
+	ExternalRef string          `json:"external_ref"` // Optional, the upstream payment processor's reference
+	Metadata    json.RawMessage `json:"metadata"`     // Optional, a JSON object stored as is
+}
+
+type TransferLegRequest struct {
 	AccountID uuid.UUID `json:"account_id"`
-	Amount    int64     `json:"amount"`
+	Amount    int64     `json:"amount"` // Negative for a debit, positive for a credit
+}
+
+type TransferRequest struct {
+	ID       uuid.UUID            `json:"id"` // Optional, a UUIDv7 is generated when omitted
+	Currency string               `json:"currency"`
+	Legs     []TransferLegRequest `json:"legs"`
+}
+
+type TransferLeg struct {
+	TransactionID uuid.UUID `json:"transaction_id"`
+	AccountID     uuid.UUID `json:"account_id"`
+	Amount        int64     `json:"amount"`
+}
+
+type TransferResponse struct {
+	ID        uuid.UUID     `json:"id"`
+	Currency  string        `json:"currency"`
+	Status    string        `json:"status"`
+	Reason    string        `json:"reason,omitempty"`
+	Legs      []TransferLeg `json:"legs"`
+	CreatedAt *time.Time    `json:"created_at,omitempty"` // Set once the worker has processed the transfer
 }
 
 type StoreRegistry interface {
 	GetAccount(ctx context.Context, id uuid.UUID) (*model.Account, error)
+	CreateAccount(ctx context.Context, id uuid.UUID, currency string, minBalance int64) (*model.Account, error)
+	ListAccounts(ctx context.Context, after uuid.UUID, limit int) ([]model.Account, error)
+	UpdateAccount(ctx context.Context, id uuid.UUID, status string, minBalance *int64) (*model.Account, error)
 	GetTransaction(ctx context.Context, id uuid.UUID) (*model.Transaction, error)
+	ListAccountTransactions(ctx context.Context, accountID uuid.UUID, filter model.TransactionFilter) ([]model.Transaction, error)
+	FindTransactionsByExternalRef(ctx context.Context, ref string, limit int) ([]model.Transaction, error)
+	GetJournalEntry(ctx context.Context, id uuid.UUID) (*model.JournalEntry, error)
+	CommittedOffsets(ctx context.Context, partitions []int32) (map[int32]int64, error)
+	CreateBatch(ctx context.Context, id uuid.UUID, produced int) error
+	GetBatch(ctx context.Context, id uuid.UUID) (*model.Batch, error)
+	ClaimIdempotencyKey(ctx context.Context, key string, requestHash []byte, expiresAt time.Time, staleBefore time.Time) (*model.IdempotencyKey, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error)
 }
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	validationStrict  = "strict"  // Any invalid record rejects the whole batch
+	validationPartial = "partial" // Invalid records are reported and the valid ones produced
+)
+
+// Reason codes reported for invalid records
+const (
+	recordMalformed          = "malformed"
+	recordInvalidID          = "invalid_id"
+	recordInvalidAccountID   = "invalid_account_id"
+	recordZeroAmount         = "zero_amount"
+	recordDuplicateID        = "duplicate_id" // Repeats the ID of an earlier record in the same batch
+	recordInvalidCurrency    = "invalid_currency"
+	recordInvalidExternalRef = "invalid_external_ref"
+	recordInvalidMetadata    = "invalid_metadata"
+)
+
+// Errors past this are counted but not itemised, so a batch of garbage cannot produce an unbounded response
+const maxReportedRecordErrors = 100
+
+/*
+** Checks a single transaction and returns a reason code and message when it is invalid, or "" when it is fine. IDs
+** are taken as bytes so protobuf records can be checked before they are known to be 16 bytes. Metadata reaches
+** Postgres as jsonb through the binary COPY, where invalid JSON would fail the whole batch on every redelivery, so
+** it is validated here rather than left to the database.
+ */
+func validateTransaction(id []byte, accountID []byte, amount int64, currency string, externalRef string, metadata []byte) (string, string) {
+	if len(id) != 16 || uuid.UUID(id) == uuid.Nil {
+		return recordInvalidID, "Invalid id, must be a non-zero UUID"
+	}
+	if len(accountID) != 16 || uuid.UUID(accountID) == uuid.Nil {
+		return recordInvalidAccountID, "Invalid account_id, must be a non-zero UUID"
+	}
+	if amount == 0 {
+		return recordZeroAmount, "Invalid amount, must not be zero"
+	}
+	if currency != "" && !validCurrencyCode(currency) {
+		return recordInvalidCurrency, "Invalid currency, must be an ISO 4217 code"
+	}
+	if len(externalRef) > maxExternalRefLength {
+		return recordInvalidExternalRef, "Invalid external_ref, must be at most 255 bytes"
+	}
+	if len(metadata) > maxMetadataSize {
+		return recordInvalidMetadata, "Invalid metadata, must be at most 4096 bytes"
+	}
+	if len(metadata) > 0 && !isJSONObject(metadata) {
+		return recordInvalidMetadata, "Invalid metadata, must be a JSON object"
+	}
+	return "", ""
+}
+
+/*
+** Validates the records of one batch, shared by the JSON, efficient JSON and protobuf handlers. On top of the
+** per-record checks it rejects IDs repeated within the batch, which the merge would otherwise silently drop.
+ */
+type batchValidator struct {
+	mode    string
+	base    int // Added to indices, so a stream of batches reports positions across the whole stream
+	seen    map[uuid.UUID]struct{}
+	skip    map[int]struct{} // Indices already rejected
+	invalid int
+	errors  []RecordError
+}
+
+func newBatchValidator(mode string, size int) *batchValidator {
+	return &batchValidator{
+		mode: mode,
+		seen: make(map[uuid.UUID]struct{}, size),
+	}
+}
+
+// Reports whether the record at index may be produced, recording why not otherwise
+func (v *batchValidator) check(index int, id []byte, accountID []byte, amount int64, currency string, externalRef string, metadata []byte) bool {
+	if v.skipped(index) {
+		return false
+	}
+
+	reason, message := validateTransaction(id, accountID, amount, currency, externalRef, metadata)
+	if reason == "" {
+		key := uuid.UUID(id)
+		if _, dup := v.seen[key]; dup {
+			reason, message = recordDuplicateID, "Duplicate id, already used by an earlier record in the batch"
+		} else {
+			v.seen[key] = struct{}{}
+		}
+	}
+
+	if reason != "" {
+		v.reject(index, reason, message)
+		return false
+	}
+	return true
+}
+
+func (v *batchValidator) reject(index int, reason string, message string) {
+	if v.skip == nil {
+		v.skip = make(map[int]struct{})
+	}
+	v.skip[v.base+index] = struct{}{}
+
+	v.invalid++
+	if len(v.errors) < maxReportedRecordErrors {
+		v.errors = append(v.errors, RecordError{Index: v.base + index, Reason: reason, Message: message})
+	}
+}
+
+// Whether the record at index was rejected and must not be produced
+func (v *batchValidator) skipped(index int) bool {
+	_, ok := v.skip[v.base+index]
+	return ok
+}
+
+// Whether the batch must be refused as a whole rather than produced without its invalid records
+func (v *batchValidator) rejectsBatch() bool {
+	return v.invalid > 0 && v.mode == validationStrict
+}
+
+func (v *batchValidator) report() []RecordError {
+	slices.SortFunc(v.errors, func(a, b RecordError) int {
+		return a.Index - b.Index
+	})
+	return v.errors
+}
+
+func (v *batchValidator) respondInvalid(c fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(ValidationErrorResponse{
+		Message:      "Invalid transactions",
+		InvalidCount: v.invalid,
+		Errors:       v.report(),
+	})
+}
+
+// Takes ?validation=strict or ?validation=partial, falling back to the server's default mode
+func (s *Server) parseValidationMode(c fiber.Ctx) (string, string) {
+	switch mode := c.Query("validation"); mode {
+	case "":
+		return s.validationMode, ""
+	case validationStrict, validationPartial:
+		return mode, ""
+	default:
+		return "", "Invalid validation, must be strict or partial"
+	}
+}
+
+/*
+** Decodes a JSON array one element at a time. Used once decoding the whole array has failed, so the elements that
+** do not decode can be reported by index rather than failing the request with no detail. Elements that fail are
+** left zero and rejected in the validator.
+ */
+func decodeElements(body []byte, unmarshal func([]byte, any) error, v *batchValidator) ([]TransactionRequest, error) {
+	var elements []json.RawMessage
+	if err := unmarshal(body, &elements); err != nil {
+		return nil, err
+	}
+
+	reqs := make([]TransactionRequest, len(elements))
+	for i, raw := range elements {
+		if err := unmarshal(raw, &reqs[i]); err != nil {
+			reqs[i] = TransactionRequest{}
+			v.reject(i, recordMalformed, "Invalid JSON for a transaction")
+		}
+	}
+	return reqs, nil
+}
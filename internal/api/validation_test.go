@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateTransaction(t *testing.T) {
+	id := uuid.New()
+	acc := uuid.New()
+
+	tests := []struct {
+		name        string
+		id          []byte
+		accountID   []byte
+		amount      int64
+		currency    string
+		externalRef string
+		metadata    []byte
+		want        string
+	}{
+		{name: "valid", id: id[:], accountID: acc[:], amount: 100},
+		{name: "valid with details", id: id[:], accountID: acc[:], amount: -100, currency: "EUR", externalRef: "ref-1", metadata: []byte(`{"a":1}`)},
+		{name: "short id", id: id[:8], accountID: acc[:], amount: 100, want: recordInvalidID},
+		{name: "nil id", id: uuid.Nil[:], accountID: acc[:], amount: 100, want: recordInvalidID},
+		{name: "missing account", id: id[:], amount: 100, want: recordInvalidAccountID},
+		{name: "nil account", id: id[:], accountID: uuid.Nil[:], amount: 100, want: recordInvalidAccountID},
+		{name: "zero amount", id: id[:], accountID: acc[:], want: recordZeroAmount},
+		{name: "lowercase currency", id: id[:], accountID: acc[:], amount: 100, currency: "usd", want: recordInvalidCurrency},
+		{name: "long currency", id: id[:], accountID: acc[:], amount: 100, currency: "USDT", want: recordInvalidCurrency},
+		{name: "long external ref", id: id[:], accountID: acc[:], amount: 100, externalRef: strings.Repeat("r", maxExternalRefLength+1), want: recordInvalidExternalRef},
+		{name: "external ref at limit", id: id[:], accountID: acc[:], amount: 100, externalRef: strings.Repeat("r", maxExternalRefLength)},
+		{name: "metadata array", id: id[:], accountID: acc[:], amount: 100, metadata: []byte(`[1]`), want: recordInvalidMetadata},
+		{name: "metadata invalid JSON", id: id[:], accountID: acc[:], amount: 100, metadata: []byte(`{"a":`), want: recordInvalidMetadata},
+		{name: "metadata too large", id: id[:], accountID: acc[:], amount: 100, metadata: []byte(`{"a":"` + strings.Repeat("x", maxMetadataSize) + `"}`), want: recordInvalidMetadata},
+		{name: "id checked first", id: nil, accountID: nil, amount: 0, want: recordInvalidID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, message := validateTransaction(tt.id, tt.accountID, tt.amount, tt.currency, tt.externalRef, tt.metadata)
+			if reason != tt.want {
+				t.Errorf("reason = %q, want %q", reason, tt.want)
+			}
+			if (reason == "") != (message == "") {
+				t.Errorf("reason %q returned with message %q", reason, message)
+			}
+		})
+	}
+}
+
+// One record fed to a validator
+type checkedRecord struct {
+	id        uuid.UUID
+	accountID uuid.UUID
+	amount    int64
+}
+
+func TestBatchValidator(t *testing.T) {
+	acc := uuid.New()
+	a := uuid.New()
+	b := uuid.New()
+
+	tests := []struct {
+		name         string
+		mode         string
+		base         int
+		records      []checkedRecord
+		wantProduced []bool
+		wantErrors   []RecordError
+		wantRejects  bool
+	}{
+		{
+			name:         "all valid",
+			mode:         validationStrict,
+			records:      []checkedRecord{{a, acc, 1}, {b, acc, -1}},
+			wantProduced: []bool{true, true},
+		},
+		{
+			name:         "strict rejects the batch",
+			mode:         validationStrict,
+			records:      []checkedRecord{{a, acc, 1}, {b, acc, 0}},
+			wantProduced: []bool{true, false},
+			wantErrors:   []RecordError{{Index: 1, Reason: recordZeroAmount}},
+			wantRejects:  true,
+		},
+		{
+			name:         "partial keeps the valid records",
+			mode:         validationPartial,
+			records:      []checkedRecord{{a, uuid.Nil, 1}, {b, acc, 1}},
+			wantProduced: []bool{false, true},
+			wantErrors:   []RecordError{{Index: 0, Reason: recordInvalidAccountID}},
+		},
+		{
+			name:         "duplicate id",
+			mode:         validationPartial,
+			records:      []checkedRecord{{a, acc, 1}, {b, acc, 1}, {a, acc, 2}},
+			wantProduced: []bool{true, true, false},
+			wantErrors:   []RecordError{{Index: 2, Reason: recordDuplicateID}},
+		},
+		{
+			name:         "invalid record does not claim its id",
+			mode:         validationPartial,
+			records:      []checkedRecord{{a, acc, 0}, {a, acc, 1}},
+			wantProduced: []bool{false, true},
+			wantErrors:   []RecordError{{Index: 0, Reason: recordZeroAmount}},
+		},
+		{
+			name:         "indices offset by base",
+			mode:         validationPartial,
+			base:         10,
+			records:      []checkedRecord{{a, acc, 1}, {b, acc, 0}},
+			wantProduced: []bool{true, false},
+			wantErrors:   []RecordError{{Index: 11, Reason: recordZeroAmount}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newBatchValidator(tt.mode, len(tt.records))
+			v.base = tt.base
+			for i, r := range tt.records {
+				if got := v.check(i, r.id[:], r.accountID[:], r.amount, "", "", nil); got != tt.wantProduced[i] {
+					t.Errorf("check(%d) = %v, want %v", i, got, tt.wantProduced[i])
+				}
+				if v.skipped(i) == tt.wantProduced[i] {
+					t.Errorf("skipped(%d) = %v, want %v", i, v.skipped(i), !tt.wantProduced[i])
+				}
+			}
+
+			if got := v.rejectsBatch(); got != tt.wantRejects {
+				t.Errorf("rejectsBatch() = %v, want %v", got, tt.wantRejects)
+			}
+			if v.invalid != len(tt.wantErrors) {
+				t.Errorf("invalid = %d, want %d", v.invalid, len(tt.wantErrors))
+			}
+			assertRecordErrors(t, v.report(), tt.wantErrors)
+		})
+	}
+}
+
+// A record rejected before it is checked, as decodeElements does for malformed JSON, stays rejected
+func TestBatchValidatorRejectedBeforeCheck(t *testing.T) {
+	v := newBatchValidator(validationPartial, 2)
+	v.reject(1, recordMalformed, "Invalid JSON for a transaction")
+	v.reject(0, recordMalformed, "Invalid JSON for a transaction")
+
+	id := uuid.New()
+	acc := uuid.New()
+	if v.check(1, id[:], acc[:], 1, "", "", nil) {
+		t.Errorf("check of a rejected record = true, want false")
+	}
+	if v.invalid != 2 {
+		t.Errorf("invalid = %d, want 2", v.invalid)
+	}
+	// Reported in index order whatever order they were rejected in
+	assertRecordErrors(t, v.report(), []RecordError{{Index: 0, Reason: recordMalformed}, {Index: 1, Reason: recordMalformed}})
+}
+
+func TestBatchValidatorTruncatesErrors(t *testing.T) {
+	const count = maxReportedRecordErrors + 25
+	v := newBatchValidator(validationPartial, count)
+	acc := uuid.New()
+	for i := range count {
+		id := uuid.New()
+		v.check(i, id[:], acc[:], 0, "", "", nil)
+	}
+
+	if v.invalid != count {
+		t.Errorf("invalid = %d, want %d", v.invalid, count)
+	}
+	errs := v.report()
+	if len(errs) != maxReportedRecordErrors {
+		t.Fatalf("reported %d errors, want %d", len(errs), maxReportedRecordErrors)
+	}
+	if last := errs[len(errs)-1].Index; last != maxReportedRecordErrors-1 {
+		t.Errorf("last reported index = %d, want %d", last, maxReportedRecordErrors-1)
+	}
+}
+
+func TestDecodeElements(t *testing.T) {
+	a := uuid.New()
+	acc := uuid.New()
+	body := []byte(`[
+		{"id":"` + a.String() + `","account_id":"` + acc.String() + `","amount":5},
+		{"id":"not-a-uuid","account_id":"` + acc.String() + `","amount":5},
+		{"id":"` + a.String() + `","amount":"five"}
+	]`)
+
+	v := newBatchValidator(validationPartial, 3)
+	reqs, err := decodeElements(body, json.Unmarshal, v)
+	if err != nil {
+		t.Fatalf("decodeElements() error = %v", err)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("decoded %d elements, want 3", len(reqs))
+	}
+	if reqs[0].ID != a || reqs[0].AccountID != acc || reqs[0].Amount != 5 {
+		t.Errorf("element 0 = %+v, want the decoded transaction", reqs[0])
+	}
+	for _, i := range []int{1, 2} {
+		if reqs[i].ID != uuid.Nil || reqs[i].Amount != 0 {
+			t.Errorf("element %d = %+v, want it left zero", i, reqs[i])
+		}
+		if !v.skipped(i) {
+			t.Errorf("skipped(%d) = false, want true", i)
+		}
+	}
+	assertRecordErrors(t, v.report(), []RecordError{{Index: 1, Reason: recordMalformed}, {Index: 2, Reason: recordMalformed}})
+
+	if _, err := decodeElements([]byte(`{"id":1}`), json.Unmarshal, newBatchValidator(validationPartial, 0)); err == nil {
+		t.Errorf("decodeElements() of a non-array succeeded, want an error")
+	}
+}
+
+// Compares indices and reasons, messages are free text
+func assertRecordErrors(t *testing.T, got []RecordError, want []RecordError) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("errors = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Index != want[i].Index || got[i].Reason != want[i].Reason {
+			t.Errorf("errors[%d] = %+v, want index %d reason %q", i, got[i], want[i].Index, want[i].Reason)
+		}
+		if got[i].Message == "" {
+			t.Errorf("errors[%d] has no message", i)
+		}
+	}
+}
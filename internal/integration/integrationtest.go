@@ -3,16 +3,20 @@ package integration
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
@@ -23,14 +27,16 @@ import (
 
 	"github.com/alexmcook/transaction-ledger/internal/api"
 	"github.com/alexmcook/transaction-ledger/internal/logger"
+	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/alexmcook/transaction-ledger/internal/storage"
 	"github.com/alexmcook/transaction-ledger/internal/worker"
+	pb "github.com/alexmcook/transaction-ledger/proto"
 )
 
 var (
-	testDB       *pgxpool.Pool
-	testBroker   *kgo.Client
-	workerClient *kgo.Client
+	testDB     *pgxpool.Pool
+	testBroker *kgo.Client
+	brokerAddr string
 )
 
 func TestMain(m *testing.M) {
@@ -42,6 +48,7 @@ func TestMain(m *testing.M) {
 		postgres.WithDatabase("testdb"),
 		postgres.WithUsername("testuser"),
 		postgres.WithPassword("testpass"),
+		testcontainers.WithCmdArgs("-c", "max_prepared_transactions=16"),
 		testcontainers.WithWaitStrategy(
 			wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(10*time.Second),
 		),
@@ -64,7 +71,7 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Failed to get Postgres connection string: %v", err)
 	}
 
-	brokerAddr, err := rp.KafkaSeedBroker(ctx)
+	brokerAddr, err = rp.KafkaSeedBroker(ctx)
 	if err != nil {
 		log.Fatalf("Failed to get Redpanda broker address: %v", err)
 	}
@@ -82,14 +89,20 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Failed to create Redpanda client: %v", err)
 	}
 
-	// Run migrations (path relative to repository root)
-	migratePath := filepath.Join("..", "..", "migrations", "001_initial_schema.up.sql")
-	migrations, err := os.ReadFile(migratePath)
+	// Run migrations in order (path relative to repository root)
+	migratePaths, err := filepath.Glob(filepath.Join("..", "..", "migrations", "*.up.sql"))
 	if err != nil {
-		log.Fatalf("Failed to read migrations: %v", err)
+		log.Fatalf("Failed to list migrations: %v", err)
 	}
-	if _, err := testDB.Exec(ctx, string(migrations)); err != nil {
-		log.Fatalf("Failed to execute migrations: %v", err)
+	sort.Strings(migratePaths)
+	for _, migratePath := range migratePaths {
+		migrations, err := os.ReadFile(migratePath)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		if _, err := testDB.Exec(ctx, string(migrations)); err != nil {
+			log.Fatalf("Failed to execute migration %s: %v", migratePath, err)
+		}
 	}
 
 	// Ensure topic exists with 64 partitions
@@ -98,29 +111,12 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Failed to create transactions topic: %v", err)
 	}
 
-	// Create a dedicated consumer client assigned to all partitions starting at beginning
-	assignments := make(map[int32]kgo.Offset)
-	for i := 0; i < 64; i++ {
-		assignments[int32(i)] = kgo.NewOffset().AtStart()
-	}
-	topicPartitions := map[string]map[int32]kgo.Offset{"transactions": assignments}
-	workerClient, err = kgo.NewClient(
-		kgo.SeedBrokers(brokerAddr),
-		kgo.ConsumePartitions(topicPartitions),
-	)
-	if err != nil {
-		log.Fatalf("Failed to create worker Redpanda client: %v", err)
-	}
-
 	code := m.Run()
 
 	testDB.Close()
 	if testBroker != nil {
 		testBroker.Close()
 	}
-	if workerClient != nil {
-		workerClient.Close()
-	}
 	if err = pg.Terminate(ctx); err != nil {
 		log.Fatalf("Failed to terminate Postgres container: %v", err)
 	}
@@ -137,7 +133,16 @@ func TestEndToEnd(t *testing.T) {
 	// Start API server wired to test DB and broker
 	logg := logger.NewLogger(slog.LevelDebug)
 	shards := storage.NewShardedStore(logg, []*pgxpool.Pool{testDB})
-	srv := api.NewServer(logg, shards, testBroker)
+	// Produce each request as a Kafka transaction, as cmd/api does
+	apiClient, err := kgo.NewClient(
+		kgo.SeedBrokers(brokerAddr),
+		kgo.TransactionalID("integration-api"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create transactional client: %v", err)
+	}
+	defer apiClient.Close()
+	srv := api.NewServer(logg, shards, api.NewTransactionalProducer(apiClient))
 	go func() {
 		if err := srv.Run(); err != nil {
 			log.Fatalf("API server failed: %v", err)
@@ -145,10 +150,31 @@ func TestEndToEnd(t *testing.T) {
 	}()
 
 	// Start worker coordinator consuming all partitions
-	coord := worker.NewCoordinator(ctx, 0, 63, logg, storage.NewPostgresStore(logg, testDB), workerClient, testDB)
+	coord, err := worker.NewCoordinator(ctx, 0, 63, logg, storage.NewPostgresStore(logg, testDB), testDB, worker.DefaultBatchConfig(),
+		kgo.SeedBrokers(brokerAddr),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create coordinator: %v", err)
+	}
+
+	// Closing the client does not stop Run, it reconnects, so it is cancelled and its writers drained before the
+	// client is closed. Otherwise it would reacquire every partition while later tests write to them.
+	runCtx, cancel := context.WithCancel(ctx)
+	runDone := make(chan struct{})
+	defer func() {
+		cancel()
+		<-runDone
+		stopCtx, stopCancel := context.WithTimeout(ctx, 30*time.Second)
+		defer stopCancel()
+		if err := coord.Stop(stopCtx); err != nil {
+			t.Errorf("Failed to stop coordinator: %v", err)
+		}
+		coord.Close()
+	}()
 	go func() {
-		if err := coord.Run(ctx); err != nil {
-			// Log and allow test to continue; coordinator may exit on client close
+		defer close(runDone)
+		if err := coord.Run(runCtx); err != nil {
 			log.Printf("Coordinator exited: %v", err)
 		}
 	}()
@@ -156,9 +182,19 @@ func TestEndToEnd(t *testing.T) {
 	// Wait for services to be ready
 	time.Sleep(2 * time.Second)
 
+	// Create the account, transactions against unknown accounts are rejected by the worker
+	acc, _ := uuid.NewV7()
+	accResp, err := http.Post("http://localhost:8080/accounts", "application/json", bytes.NewBufferString(fmt.Sprintf(`{"id":"%s"}`, acc.String())))
+	if err != nil {
+		t.Fatalf("Failed to POST account to API: %v", err)
+	}
+	accResp.Body.Close()
+	if accResp.StatusCode != 201 {
+		t.Fatalf("Unexpected account status code: %d", accResp.StatusCode)
+	}
+
 	// Post a small transaction batch
 	id, _ := uuid.NewV7()
-	acc, _ := uuid.NewV7()
 	payload := fmt.Sprintf(`[{"id":"%s","account_id":"%s","amount":100}]`, id.String(), acc.String())
 	resp, err := http.Post("http://localhost:8080/transactions/effjson", "application/json", bytes.NewBufferString(payload))
 	if err != nil {
@@ -190,3 +226,420 @@ func TestEndToEnd(t *testing.T) {
 		t.Fatalf("Timed out waiting for transaction to be written to DB")
 	}
 }
+
+func TestWriteBehindConcurrentWithMerge(t *testing.T) {
+	ctx := context.Background()
+	logg := logger.NewLogger(slog.LevelInfo)
+	store := storage.NewPostgresStore(logg, testDB)
+
+	const partition = 63
+	const numBatches = 200
+	const batchSize = 100
+
+	accounts := make([]uuid.UUID, 10)
+	for i := range accounts {
+		accounts[i], _ = uuid.NewV7()
+		if _, err := testDB.Exec(ctx, `INSERT INTO accounts (id, balance, created_at) VALUES ($1, 0, NOW())`, accounts[i]); err != nil {
+			t.Fatalf("Failed to insert account: %v", err)
+		}
+	}
+
+	// Write behind as fast as possible so it races with every merge into the same partition table
+	writeBehind := worker.NewWriteBehindWorker(logg, testDB, partition, partition, 5*time.Millisecond)
+	writeBehind.Start(ctx)
+
+	expected := make(map[uuid.UUID]int64)
+	source := storage.NewEfficientTransactionSource(false)
+	epoch, err := store.Transactions().AcquirePartition(ctx, partition)
+	if err != nil {
+		t.Fatalf("Failed to acquire partition: %v", err)
+	}
+	source.Epoch = epoch
+	for b := range numBatches {
+		for i := range batchSize {
+			id, _ := uuid.NewV7()
+			acc := accounts[i%len(accounts)]
+			amount := int64(b*batchSize + i + 1)
+			source.Txs[i].Id = id[:]
+			source.Txs[i].AccountId = acc[:]
+			source.Txs[i].Amount = amount
+			expected[acc] += amount
+		}
+		source.Count = batchSize
+		source.Offset = int64(b)
+
+		if err := store.Transactions().EfficientWriteBatch(ctx, partition, source); err != nil {
+			t.Fatalf("Failed to write batch %d: %v", b, err)
+		}
+		source.Reset()
+	}
+
+	// Wait until every merged row has been written behind
+	drained := false
+	start := time.Now()
+	for time.Since(start) < 30*time.Second {
+		var pending int
+		err := testDB.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM transactions_%d WHERE account_id = ANY($1)", partition), accounts).Scan(&pending)
+		if err != nil {
+			t.Fatalf("Failed to count pending transactions: %v", err)
+		}
+		if pending == 0 {
+			drained = true
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	writeBehind.Stop(ctx)
+	if !drained {
+		t.Fatalf("Timed out waiting for write behind to drain partition %d", partition)
+	}
+
+	for _, acc := range accounts {
+		var balance int64
+		if err := testDB.QueryRow(ctx, `SELECT balance FROM accounts WHERE id = $1`, acc).Scan(&balance); err != nil {
+			t.Fatalf("Failed to read balance: %v", err)
+		}
+		if balance != expected[acc] {
+			t.Errorf("Account %s balance = %d, want %d", acc, balance, expected[acc])
+		}
+	}
+}
+
+func TestMinBalanceRejectsOverdraft(t *testing.T) {
+	ctx := context.Background()
+	logg := logger.NewLogger(slog.LevelInfo)
+	store := storage.NewPostgresStore(logg, testDB)
+
+	const partition = 62
+
+	account, _ := uuid.NewV7()
+	if _, err := testDB.Exec(ctx, `INSERT INTO accounts (id, balance, min_balance, created_at) VALUES ($1, 100, -50, NOW())`, account); err != nil {
+		t.Fatalf("Failed to insert account: %v", err)
+	}
+
+	// Headroom starts at 150, rejected debits must not consume it
+	amounts := []int64{-100, -60, 20, -70, -1}
+	wantRejected := []bool{false, true, false, false, true}
+
+	source := storage.NewEfficientTransactionSource(false)
+	ids := make([]uuid.UUID, len(amounts))
+	for i, amount := range amounts {
+		ids[i], _ = uuid.NewV7()
+		source.Txs[i].Id = ids[i][:]
+		source.Txs[i].AccountId = account[:]
+		source.Txs[i].Amount = amount
+	}
+	// A redelivered debit shares the outcome of its first occurrence
+	source.Txs[len(amounts)].Id = ids[0][:]
+	source.Txs[len(amounts)].AccountId = account[:]
+	source.Txs[len(amounts)].Amount = amounts[0]
+	source.Count = len(amounts) + 1
+	source.Offset = 0
+
+	epoch, err := store.Transactions().AcquirePartition(ctx, partition)
+	if err != nil {
+		t.Fatalf("Failed to acquire partition: %v", err)
+	}
+	source.Epoch = epoch
+
+	if err := store.Transactions().EfficientWriteBatch(ctx, partition, source); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	if source.Merged != 3 || source.Rejected != 2 {
+		t.Fatalf("Merged %d and rejected %d, want 3 and 2", source.Merged, source.Rejected)
+	}
+
+	for i, id := range ids {
+		var reason string
+		err := testDB.QueryRow(ctx, `SELECT reason FROM rejected_transactions WHERE id = $1`, id).Scan(&reason)
+		rejected := err == nil
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			t.Fatalf("Failed to read rejected transaction: %v", err)
+		}
+		if rejected != wantRejected[i] {
+			t.Errorf("Transaction %d (amount %d) rejected = %v, want %v", i, amounts[i], rejected, wantRejected[i])
+		}
+		if rejected && reason != model.RejectReasonInsufficientFunds {
+			t.Errorf("Transaction %d reject reason = %q, want %q", i, reason, model.RejectReasonInsufficientFunds)
+		}
+	}
+
+	var pending int64
+	err = testDB.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(SUM(amount), 0) FROM transactions_%d WHERE account_id = $1", partition), account).Scan(&pending)
+	if err != nil {
+		t.Fatalf("Failed to sum pending transactions: %v", err)
+	}
+	if 100+pending != -50 {
+		t.Errorf("Balance after merge = %d, want -50", 100+pending)
+	}
+}
+
+func TestTransferAcrossShards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logg := logger.NewLogger(slog.LevelInfo)
+
+	// Two pools on the same database stand in for two shards, accounts are split by partition range
+	otherShard, err := pgxpool.New(ctx, testDB.Config().ConnString())
+	if err != nil {
+		t.Fatalf("Failed to connect second shard: %v", err)
+	}
+	defer otherShard.Close()
+	pools := []*pgxpool.Pool{testDB, otherShard}
+
+	newAccount := func(shard int, balance int64) uuid.UUID {
+		for {
+			id, _ := uuid.NewV7()
+			if storage.ShardForPartition(storage.PartitionForAccount(id), len(pools)) != shard {
+				continue
+			}
+			if _, err := testDB.Exec(ctx, `INSERT INTO accounts (id, balance, created_at) VALUES ($1, $2, NOW())`, id, balance); err != nil {
+				t.Fatalf("Failed to insert account: %v", err)
+			}
+			return id
+		}
+	}
+	from := newAccount(0, 100)
+	to := newAccount(1, 0)
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokerAddr),
+		kgo.ConsumerGroup("journal-test"),
+		kgo.ConsumeTopics("journal"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.DisableAutoCommit(),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create journal client: %v", err)
+	}
+	defer client.Close()
+
+	processor := worker.NewJournalProcessor(logg, client, pools)
+	go processor.Run(ctx)
+
+	submit := func(amount int64) uuid.UUID {
+		id, _ := uuid.NewV7()
+		msg := &pb.JournalEntry{
+			Id:       id[:],
+			Currency: "USD",
+			Legs: []*pb.JournalLeg{
+				{AccountId: from[:], Amount: -amount},
+				{AccountId: to[:], Amount: amount},
+			},
+		}
+		payload, err := msg.MarshalVT()
+		if err != nil {
+			t.Fatalf("Failed to marshal journal entry: %v", err)
+		}
+		if err := testBroker.ProduceSync(ctx, &kgo.Record{Topic: "journal", Key: id[:], Value: payload}).FirstErr(); err != nil {
+			t.Fatalf("Failed to produce journal entry: %v", err)
+		}
+		return id
+	}
+	waitFor := func(id uuid.UUID) *model.JournalEntry {
+		home := storage.NewJournalStore(pools[storage.ShardForPartition(storage.PartitionForAccount(id), len(pools))])
+		for start := time.Now(); time.Since(start) < 30*time.Second; time.Sleep(100 * time.Millisecond) {
+			entry, err := home.GetJournalEntry(ctx, id)
+			if err != nil {
+				t.Fatalf("Failed to read journal entry: %v", err)
+			}
+			if entry != nil {
+				return entry
+			}
+		}
+		t.Fatalf("Timed out waiting for journal entry %s", id)
+		return nil
+	}
+	currentBalance := func(id uuid.UUID) int64 {
+		account, err := storage.NewAccountStore(testDB).GetAccount(ctx, id)
+		if err != nil || account == nil {
+			t.Fatalf("Failed to read account %s: %v", id, err)
+		}
+		return account.Balance + account.PendingBalance
+	}
+
+	applied := waitFor(submit(60))
+	if applied.Status != model.JournalStatusApplied {
+		t.Fatalf("Transfer status = %q (%s), want %q", applied.Status, applied.Reason, model.JournalStatusApplied)
+	}
+
+	// Only 40 is left, neither leg of the second transfer may be applied
+	rejected := waitFor(submit(50))
+	if rejected.Status != model.JournalStatusRejected || rejected.Reason != model.RejectReasonInsufficientFunds {
+		t.Fatalf("Transfer status = %q (%s), want %q (%s)", rejected.Status, rejected.Reason, model.JournalStatusRejected, model.RejectReasonInsufficientFunds)
+	}
+
+	if got := currentBalance(from); got != 40 {
+		t.Errorf("Debited account balance = %d, want 40", got)
+	}
+	if got := currentBalance(to); got != 60 {
+		t.Errorf("Credited account balance = %d, want 60", got)
+	}
+
+	var inDoubt int
+	if err := testDB.QueryRow(ctx, `SELECT COUNT(*) FROM pg_prepared_xacts`).Scan(&inDoubt); err != nil {
+		t.Fatalf("Failed to count prepared transactions: %v", err)
+	}
+	if inDoubt != 0 {
+		t.Errorf("Prepared transactions left behind = %d, want 0", inDoubt)
+	}
+}
+
+func TestTransactionalProduceAbortsOnFailure(t *testing.T) {
+	ctx := context.Background()
+	const topic = "transactional-abort"
+
+	adm := kadm.NewClient(testBroker)
+	if _, err := adm.CreateTopics(ctx, 4, 1, nil, topic); err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokerAddr),
+		kgo.TransactionalID("integration-abort"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create transactional client: %v", err)
+	}
+	defer client.Close()
+	producer := api.NewTransactionalProducer(client)
+
+	// The middle record is larger than a produce batch may be, so it fails after the first was already produced
+	failed := []*kgo.Record{
+		{Topic: topic, Key: []byte("a"), Value: []byte("aborted-1")},
+		{Topic: topic, Key: []byte("b"), Value: make([]byte, 2*1024*1024)},
+		{Topic: topic, Key: []byte("c"), Value: []byte("aborted-2")},
+	}
+	if err := producer.Produce(ctx, failed...); err == nil {
+		t.Fatalf("Produce with an oversized record succeeded, want an error")
+	}
+
+	// The client must be able to begin the next transaction after the abort, its commit also bounds the read below
+	if err := producer.Produce(ctx, &kgo.Record{Topic: topic, Key: []byte("a"), Value: []byte("committed")}); err != nil {
+		t.Fatalf("Produce after an aborted transaction failed: %v", err)
+	}
+
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(brokerAddr),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	pollCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var seen []string
+	for !slices.Contains(seen, "committed") {
+		fetches := consumer.PollFetches(pollCtx)
+		if pollCtx.Err() != nil {
+			t.Fatalf("Timed out waiting for the committed record, saw %q", seen)
+		}
+		fetches.EachRecord(func(rec *kgo.Record) {
+			seen = append(seen, string(rec.Value))
+		})
+	}
+	if len(seen) != 1 {
+		t.Errorf("Read-committed consumer saw %q, want only the committed record", seen)
+	}
+}
+
+func TestPartitionFencing(t *testing.T) {
+	ctx := context.Background()
+	logg := logger.NewLogger(slog.LevelInfo)
+	store := storage.NewPostgresStore(logg, testDB)
+
+	const partition = 61
+
+	acc, _ := uuid.NewV7()
+	if _, err := testDB.Exec(ctx, `INSERT INTO accounts (id, balance, created_at) VALUES ($1, 0, NOW())`, acc); err != nil {
+		t.Fatalf("Failed to insert account: %v", err)
+	}
+
+	lastOffset := func() int64 {
+		var offset int64
+		if err := testDB.QueryRow(ctx, `SELECT last_offset FROM kafka_offsets WHERE partition_id = $1`, partition).Scan(&offset); err != nil {
+			t.Fatalf("Failed to read offset: %v", err)
+		}
+		return offset
+	}
+	// Writes a batch of new transactions at offset and returns the error with how many of them were recorded
+	write := func(epoch int64, offset int64) (int, error) {
+		source := storage.NewEfficientTransactionSource(false)
+		source.Epoch = epoch
+		ids := make([]uuid.UUID, 10)
+		for i := range ids {
+			ids[i], _ = uuid.NewV7()
+			source.Txs[i].Id = ids[i][:]
+			source.Txs[i].AccountId = acc[:]
+			source.Txs[i].Amount = 1
+		}
+		source.Count = len(ids)
+		source.Offset = offset
+		writeErr := store.Transactions().EfficientWriteBatch(ctx, partition, source)
+
+		var recorded int
+		if err := testDB.QueryRow(ctx, `SELECT COUNT(*) FROM transaction_ids WHERE id = ANY($1)`, ids).Scan(&recorded); err != nil {
+			t.Fatalf("Failed to count recorded transactions: %v", err)
+		}
+		var staged int
+		if err := testDB.QueryRow(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM staging_%d`, partition)).Scan(&staged); err != nil {
+			t.Fatalf("Failed to count staged transactions: %v", err)
+		}
+		if staged != 0 {
+			t.Errorf("Staging table holds %d rows after the write, want 0", staged)
+		}
+		return recorded, writeErr
+	}
+
+	stale, err := store.Transactions().AcquirePartition(ctx, partition)
+	if err != nil {
+		t.Fatalf("Failed to acquire partition: %v", err)
+	}
+	current, err := store.Transactions().AcquirePartition(ctx, partition)
+	if err != nil {
+		t.Fatalf("Failed to acquire partition: %v", err)
+	}
+	base := lastOffset()
+
+	// The replaced writer's batch is refused and commits nothing
+	recorded, err := write(stale, base+10)
+	if !errors.Is(err, storage.ErrPartitionFenced) {
+		t.Fatalf("Write with a stale epoch returned %v, want %v", err, storage.ErrPartitionFenced)
+	}
+	if recorded != 0 {
+		t.Errorf("Write with a stale epoch recorded %d transactions, want 0", recorded)
+	}
+	if got := lastOffset(); got != base {
+		t.Errorf("Offset after a fenced write = %d, want %d", got, base)
+	}
+
+	recorded, err = write(current, base+5)
+	if err != nil {
+		t.Fatalf("Write with the current epoch failed: %v", err)
+	}
+	if recorded != 10 {
+		t.Errorf("Write with the current epoch recorded %d transactions, want 10", recorded)
+	}
+	if got := lastOffset(); got != base+5 {
+		t.Errorf("Offset after the write = %d, want %d", got, base+5)
+	}
+
+	// Batches at or below the committed offset are skipped and the offset never moves back
+	for _, offset := range []int64{base + 5, base + 3} {
+		recorded, err = write(current, offset)
+		if err != nil {
+			t.Fatalf("Write of an already committed offset %d failed: %v", offset, err)
+		}
+		if recorded != 0 {
+			t.Errorf("Write of an already committed offset %d recorded %d transactions, want 0", offset, recorded)
+		}
+		if got := lastOffset(); got != base+5 {
+			t.Errorf("Offset after writing offset %d = %d, want %d", offset, got, base+5)
+		}
+	}
+}
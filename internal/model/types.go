@@ -6,15 +6,118 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	AccountStatusActive = "active"
+	AccountStatusFrozen = "frozen" // Incoming transactions are rejected until the account is reactivated
+	AccountStatusClosed = "closed" // Terminal, incoming transactions are rejected
+)
+
+// Reason codes recorded in rejected_transactions
+const (
+	RejectReasonAccountNotFound   = "account_not_found"
+	RejectReasonAccountFrozen     = "account_frozen"
+	RejectReasonAccountClosed     = "account_closed"
+	RejectReasonInsufficientFunds = "insufficient_funds" // Would take the balance below the account's min_balance
+	RejectReasonInvalidEntry      = "invalid_entry"      // Journal entry legs are malformed or do not net to zero
+	RejectReasonCurrencyMismatch  = "currency_mismatch"  // Sent in a different currency than the account holds
+)
+
 type Account struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	Balance   int64     `json:"balance" db:"balance"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID             uuid.UUID `json:"id" db:"id"`
+	Balance        int64     `json:"balance" db:"balance"`                 // Persisted by write-behind
+	PendingBalance int64     `json:"pending_balance" db:"pending_balance"` // Merged but not yet written behind
+	MinBalance     int64     `json:"min_balance" db:"min_balance"`         // Negative for an overdraft limit
+	Currency       string    `json:"currency" db:"currency"`
+	MinorUnits     int16     `json:"minor_units" db:"minor_units"` // Decimal places of the currency's minor unit
+	Partition      int32     `json:"partition" db:"partition_id"`
+	LastOffset     int64     `json:"last_offset" db:"last_offset"` // Kafka offset the balances are current as of
+	Status         string    `json:"status" db:"status"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Lifecycle stage a transaction is found in, staging_N is only visible inside the worker's merge transaction
+const (
+	TransactionStageMerged   = "merged"   // In transactions_N, not yet applied to the account balance
+	TransactionStageArchived = "archived" // Applied to the account balance and moved to transactions_history
+	TransactionStageRejected = "rejected" // Refused by the worker and recorded in rejected_transactions
+)
+
 type Transaction struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	AccountID uuid.UUID `json:"account_id" db:"account_id"`
-	Amount    int64     `json:"amount" db:"amount"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          uuid.UUID  `json:"id" db:"id"`
+	AccountID   uuid.UUID  `json:"account_id" db:"account_id"`
+	Amount      int64      `json:"amount" db:"amount"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`     // When the ledger accepted it
+	OccurredAt  *time.Time `json:"occurred_at" db:"occurred_at"`   // When it happened upstream, if the client said
+	ExternalRef string     `json:"external_ref" db:"external_ref"` // Upstream payment processor reference
+	Metadata    []byte     `json:"metadata" db:"metadata"`         // Free-form JSON object
+	Stage       string     `json:"stage" db:"stage"`
+	Reason      string     `json:"reason" db:"reason"` // Set when the transaction was rejected
+}
+
+// Kafka record header carrying the 16 byte ID of the ingestion request a transaction was produced in
+const BatchIDHeader = "batch_id"
+
+// Topic holding transaction records the worker could not decode or validate, with headers saying why and where
+// each came from
+const DeadLetterTopic = "transactions.dlq"
+
+const (
+	DeadLetterErrorHeader     = "dlq_error"
+	DeadLetterPartitionHeader = "dlq_source_partition"
+	DeadLetterOffsetHeader    = "dlq_source_offset"
+)
+
+type Batch struct {
+	ID            uuid.UUID `json:"id" db:"id"`
+	Produced      int       `json:"produced" db:"produced"`
+	Staged        int       `json:"staged" db:"staged"`
+	Merged        int       `json:"merged" db:"merged"`
+	Rejected      int       `json:"rejected" db:"rejected"`
+	DeadLettered  int       `json:"dead_lettered" db:"dead_lettered"`
+	WrittenBehind int       `json:"written_behind" db:"written_behind"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+type IdempotencyKey struct {
+	Key         string    `json:"key" db:"key"`
+	RequestHash []byte    `json:"request_hash" db:"request_hash"`
+	StatusCode  int       `json:"status_code" db:"status_code"`
+	Response    []byte    `json:"response" db:"response"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// Position of the last transaction on a page, pages are ordered newest first by (created_at, id)
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type TransactionFilter struct {
+	After *TransactionCursor
+	From  time.Time // Inclusive, zero for unbounded
+	To    time.Time // Exclusive, zero for unbounded
+	Limit int
+}
+
+const (
+	JournalStatusApplied  = "applied"
+	JournalStatusRejected = "rejected"
+)
+
+// Double-entry transfer, every leg is merged as a transaction on the shard owning its account
+type JournalEntry struct {
+	ID        uuid.UUID    `json:"id" db:"id"`
+	Currency  string       `json:"currency" db:"currency"`
+	Status    string       `json:"status" db:"status"`
+	Reason    string       `json:"reason" db:"reason"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	Legs      []JournalLeg `json:"legs" db:"-"`
+}
+
+type JournalLeg struct {
+	TransactionID uuid.UUID `json:"transaction_id" db:"transaction_id"`
+	AccountID     uuid.UUID `json:"account_id" db:"account_id"`
+	Amount        int64     `json:"amount" db:"amount"`
 }
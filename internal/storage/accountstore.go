@@ -3,20 +3,59 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	ErrAccountExists   = errors.New("account already exists")
+	ErrAccountClosed   = errors.New("account is closed")
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
 type AccountStore struct {
-	pool *pgxpool.Pool
+	pool       *pgxpool.Pool
+	getQueries [NumPartitions]string
+}
+
+func NewAccountStore(pool *pgxpool.Pool) *AccountStore {
+	as := &AccountStore{
+		pool: pool,
+	}
+
+	for i := range NumPartitions {
+		// A single statement reads the balance, the merged rows not yet written behind and the offset they are
+		// current as of from one snapshot. Write-behind moves amounts from transactions_N into the balance
+		// atomically, so their sum never double counts.
+		as.getQueries[i] = fmt.Sprintf(`
+		SELECT
+			a.id,
+			a.balance,
+			a.min_balance,
+			a.currency,
+			c.minor_units,
+			COALESCE((SELECT SUM(t.amount) FROM transactions_%d t WHERE t.account_id = a.id), 0)::BIGINT AS pending_balance,
+			%d AS partition_id,
+			COALESCE((SELECT o.last_offset FROM kafka_offsets o WHERE o.partition_id = %d), -1) AS last_offset,
+			a.status,
+			a.created_at,
+			a.updated_at
+		FROM accounts a
+		JOIN currencies c ON c.code = a.currency
+		WHERE a.id = $1
+	`, i, i, i)
+	}
+
+	return as
 }
 
 func (as *AccountStore) GetAccount(ctx context.Context, id uuid.UUID) (*model.Account, error) {
-	const getAccountQuery = `SELECT id, user_id, balance, created_at FROM accounts WHERE id = $1`
-	rows, err := as.pool.Query(ctx, getAccountQuery, id)
+	rows, err := as.pool.Query(ctx, as.getQueries[PartitionForAccount(id)], id)
 	if err != nil {
 		return nil, err
 	}
@@ -31,3 +70,79 @@ func (as *AccountStore) GetAccount(ctx context.Context, id uuid.UUID) (*model.Ac
 
 	return &account, nil
 }
+
+func (as *AccountStore) CreateAccount(ctx context.Context, id uuid.UUID, currency string, minBalance int64) (*model.Account, error) {
+	const createAccountQuery = `
+		INSERT INTO accounts (id, balance, min_balance, currency, status, created_at, updated_at)
+		VALUES ($1, 0, $2, $3, $4, NOW(), NOW())
+		ON CONFLICT (id) DO NOTHING
+	`
+	tag, err := as.pool.Exec(ctx, createAccountQuery, id, minBalance, currency, model.AccountStatusActive)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" { // foreign_key_violation on accounts_currency_fkey
+			return nil, ErrUnknownCurrency
+		}
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAccountExists
+	}
+
+	return as.GetAccount(ctx, id)
+}
+
+/*
+** Keyset pagination ordered by ID, pending balances are only computed for single account reads
+ */
+func (as *AccountStore) ListAccounts(ctx context.Context, after uuid.UUID, limit int) ([]model.Account, error) {
+	const listAccountsQuery = `
+		SELECT a.id, a.balance, a.min_balance, a.currency, c.minor_units, a.status, a.created_at, a.updated_at
+		FROM accounts a
+		JOIN currencies c ON c.code = a.currency
+		WHERE a.id > $1
+		ORDER BY a.id
+		LIMIT $2
+	`
+	rows, err := as.pool.Query(ctx, listAccountsQuery, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[model.Account])
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		accounts[i].Partition = PartitionForAccount(accounts[i].ID)
+	}
+
+	return accounts, nil
+}
+
+/*
+** Updates the status and/or minimum balance, an empty status or nil minBalance is left unchanged. Closed accounts are
+** terminal, any other change is allowed. A new minimum balance only applies to transactions merged after it.
+ */
+func (as *AccountStore) UpdateAccount(ctx context.Context, id uuid.UUID, status string, minBalance *int64) (*model.Account, error) {
+	const updateAccountQuery = `
+		UPDATE accounts
+		SET status = COALESCE(NULLIF($2, ''), status), min_balance = COALESCE($3, min_balance), updated_at = NOW()
+		WHERE id = $1 AND status <> $4
+	`
+	tag, err := as.pool.Exec(ctx, updateAccountQuery, id, status, minBalance, model.AccountStatusClosed)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := as.GetAccount(ctx, id)
+	if err != nil || account == nil {
+		return account, err
+	}
+	if tag.RowsAffected() == 0 && account.Status == model.AccountStatusClosed {
+		return nil, ErrAccountClosed
+	}
+
+	return account, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BatchStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewBatchStore(pool *pgxpool.Pool) *BatchStore {
+	return &BatchStore{pool: pool}
+}
+
+// Records how many transactions an ingestion request produced, once they are all in Kafka
+func (bs *BatchStore) CreateBatch(ctx context.Context, id uuid.UUID, produced int) error {
+	const query = `INSERT INTO batches (id, produced) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`
+	_, err := bs.pool.Exec(ctx, query, id, produced)
+	return err
+}
+
+// Returns the batch without worker progress, nil if this shard never recorded it
+func (bs *BatchStore) GetBatch(ctx context.Context, id uuid.UUID) (*model.Batch, error) {
+	const query = `SELECT id, produced, created_at FROM batches WHERE id = $1`
+	rows, err := bs.pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := pgx.CollectOneRow(rows, pgx.RowToStructByNameLax[model.Batch])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// Adds the progress this shard's workers have made on a batch to its counters
+func (bs *BatchStore) AddBatchProgress(ctx context.Context, batch *model.Batch) error {
+	const query = `
+		SELECT COALESCE(SUM(staged), 0), COALESCE(SUM(merged), 0), COALESCE(SUM(rejected), 0),
+			COALESCE(SUM(dead_lettered), 0), COALESCE(SUM(written_behind), 0)
+		FROM batch_progress WHERE batch_id = $1
+	`
+	var staged, merged, rejected, deadLettered, writtenBehind int
+	err := bs.pool.QueryRow(ctx, query, batch.ID).Scan(&staged, &merged, &rejected, &deadLettered, &writtenBehind)
+	if err != nil {
+		return err
+	}
+
+	batch.Staged += staged
+	batch.Merged += merged
+	batch.Rejected += rejected
+	batch.DeadLettered += deadLettered
+	batch.WrittenBehind += writtenBehind
+	return nil
+}
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 var bufPool = sync.Pool{
@@ -16,7 +20,8 @@ var bufPool = sync.Pool{
 }
 
 /*
-** Efficient WriteBatch implementation, utilizing zero-copy techniques to direct binary copy into staging table
+** Efficient WriteBatch implementation, utilizing zero-copy techniques to direct binary copy into staging table.
+** Batches are written under the epoch in source.Epoch, see AcquirePartition.
  */
 func (ts *TransactionStore) EfficientWriteBatch(ctx context.Context, workerId int, source *EfficientTransactionSource) error {
 	now := time.Now()
@@ -27,6 +32,19 @@ func (ts *TransactionStore) EfficientWriteBatch(ctx context.Context, workerId in
 	}
 	defer tx.Rollback(ctx)
 
+	ahead, err := ts.lockPartition(ctx, tx, workerId, source)
+	if err != nil {
+		return err
+	}
+	if !ahead {
+		source.Merged, source.Rejected = 0, 0
+		return nil
+	}
+
+	if err := ts.checkFunds(ctx, tx, workerId, source); err != nil {
+		return err
+	}
+
 	bPtr := bufPool.Get().(*[]byte)
 	buf := (*bPtr)[:0]
 	defer func() {
@@ -51,21 +69,56 @@ func (ts *TransactionStore) EfficientWriteBatch(ctx context.Context, workerId in
 		return err
 	}
 
-	_, err = tx.Exec(ctx, ts.mergeQueries[workerId])
+	err = tx.QueryRow(ctx, ts.mergeQueries[workerId]).Scan(&source.Merged, &source.Rejected)
 	if err != nil {
 		return err
 	}
 
+	if err := ts.countDeadLetters(ctx, tx, workerId, source, now); err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(ctx, ts.truncateQueries[workerId])
 	if err != nil {
 		return err
 	}
 
-	const kafkaOffset = `UPDATE kafka_offsets SET last_offset = $1, updated_at = $2 WHERE partition_id = $3`
-	_, err = tx.Exec(ctx, kafkaOffset, source.Offset, now, workerId)
+	// Offsets only move forward and only for the writer holding the epoch, the row lock already ensures both
+	const kafkaOffset = `UPDATE kafka_offsets SET last_offset = $1, updated_at = $2 WHERE partition_id = $3 AND epoch = $4 AND last_offset < $1`
+	tag, err := tx.Exec(ctx, kafkaOffset, source.Offset, now, workerId, source.Epoch)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() != 1 {
+		return fmt.Errorf("%w: offset %d not committed for partition %d", ErrPartitionFenced, source.Offset, workerId)
+	}
 
 	return tx.Commit(ctx)
 }
+
+/*
+** Counts the records of each batch that were dead lettered rather than staged, in the transaction that commits the
+** offset past them, so a batch's progress reaches its produced count once every record is accounted for.
+ */
+func (ts *TransactionStore) countDeadLetters(ctx context.Context, tx pgx.Tx, workerId int, source *EfficientTransactionSource, now time.Time) error {
+	if len(source.DeadLettered) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(source.DeadLettered))
+	counts := make([]int32, 0, len(source.DeadLettered))
+	for id, n := range source.DeadLettered {
+		ids = append(ids, id)
+		counts = append(counts, int32(n))
+	}
+
+	const query = `
+		INSERT INTO batch_progress AS p (batch_id, partition_id, dead_lettered, updated_at)
+		SELECT d.batch_id, $1, d.n, $4 FROM UNNEST($2::uuid[], $3::int[]) AS d (batch_id, n)
+		ON CONFLICT (batch_id, partition_id) DO UPDATE SET
+			dead_lettered = p.dead_lettered + EXCLUDED.dead_lettered,
+			updated_at = EXCLUDED.updated_at
+	`
+	_, err := tx.Exec(ctx, query, workerId, ids, counts, now)
+	return err
+}
@@ -5,15 +5,26 @@ import (
 	"time"
 
 	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// Records a source holds, the most a worker batch may contain
+const MaxSourceRecords = 50000
+
 type EfficientTransactionSource struct {
 	Txs       []pb.Transaction
 	idx       int
 	Count     int
 	Offset    int64
+	Epoch     int64 // Writer's epoch from AcquirePartition, kept across Reset
 	Timestamp time.Time
+	Merged    int // Rows merged by the last write, excludes duplicates and rejections
+	Rejected  int
+	Reasons   []string    // Reject reason decided before the merge, empty when the row is left to the merge
+	BatchIDs  []uuid.UUID // Batch each record was produced in, from its record header
+
+	DeadLettered map[uuid.UUID]int // Records of each batch sent to the DLQ instead of being staged
 
 	idBuf  pgtype.UUID
 	accBuf pgtype.UUID
@@ -22,15 +33,20 @@ type EfficientTransactionSource struct {
 
 	buf []any
 
-	salt uint32
+	rewriteIDs bool // Load testing only, replayed batches would otherwise be deduplicated
+	salt       uint32
 }
 
-func NewEfficientTransactionSource() *EfficientTransactionSource {
+func NewEfficientTransactionSource(rewriteIDs bool) *EfficientTransactionSource {
 	return &EfficientTransactionSource{
-		Txs:  make([]pb.Transaction, 50000),
-		idx:  -1,
-		buf:  make([]any, 4),
-		salt: uint32(time.Now().UnixNano()),
+		Txs:          make([]pb.Transaction, MaxSourceRecords),
+		Reasons:      make([]string, MaxSourceRecords),
+		BatchIDs:     make([]uuid.UUID, MaxSourceRecords),
+		DeadLettered: make(map[uuid.UUID]int),
+		idx:          -1,
+		buf:          make([]any, 4),
+		rewriteIDs:   rewriteIDs,
+		salt:         uint32(time.Now().UnixNano()),
 	}
 }
 
@@ -41,9 +57,10 @@ func (ts *EfficientTransactionSource) Next() bool {
 
 func (ts *EfficientTransactionSource) Values() ([]any, error) {
 	tx := &ts.Txs[ts.idx]
-	// LOAD TESTING modify ID to avoid collisions
-	binary.BigEndian.PutUint32(tx.Id[0:4], ts.salt)
-	ts.salt++
+	if ts.rewriteIDs {
+		binary.BigEndian.PutUint32(tx.Id[0:4], ts.salt)
+		ts.salt++
+	}
 
 	copy(ts.idBuf.Bytes[:], tx.Id)
 	ts.idBuf.Valid = true
@@ -65,15 +82,20 @@ func (ts *EfficientTransactionSource) Err() error {
 func (ts *EfficientTransactionSource) Reset() {
 	ts.idx = -1
 	ts.Offset = -1
+	ts.Merged = 0
+	ts.Rejected = 0
+	clear(ts.DeadLettered)
 }
 
 func (ts *EfficientTransactionSource) EncodeRow(buf []byte, idx int, now uint64) []byte {
 	tx := &ts.Txs[idx]
-	ts.salt++
-	binary.BigEndian.PutUint32(tx.Id[0:4], ts.salt)
+	if ts.rewriteIDs {
+		ts.salt++
+		binary.BigEndian.PutUint32(tx.Id[0:4], ts.salt)
+	}
 
 	// Number of columns
-	buf = binary.BigEndian.AppendUint16(buf, 4)
+	buf = binary.BigEndian.AppendUint16(buf, 10)
 
 	// Column 1: id (UUID)
 	buf = binary.BigEndian.AppendUint32(buf, 16)
@@ -91,5 +113,53 @@ func (ts *EfficientTransactionSource) EncodeRow(buf []byte, idx int, now uint64)
 	buf = binary.BigEndian.AppendUint32(buf, 8)
 	buf = binary.BigEndian.AppendUint64(buf, now)
 
+	// Column 5: reject_reason (TEXT), NULL unless the funds check rejected the row
+	if reason := ts.Reasons[idx]; reason != "" {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(reason)))
+		buf = append(buf, reason...)
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, 0xffffffff)
+	}
+
+	// Column 6: currency (TEXT), NULL when unspecified
+	buf = appendText(buf, tx.Currency)
+
+	// Column 7: occurred_at (TIMESTAMPTZ), NULL when unspecified
+	if tx.OccurredAt != 0 {
+		buf = binary.BigEndian.AppendUint32(buf, 8)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(tx.OccurredAt-946684800*1e6)) // Rebase from the Unix epoch to 2000-01-01
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, 0xffffffff)
+	}
+
+	// Column 8: external_ref (TEXT), NULL when unspecified
+	buf = appendText(buf, tx.ExternalRef)
+
+	// Column 9: metadata (JSONB), version 1 followed by the JSON text
+	if len(tx.Metadata) > 0 {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(tx.Metadata)+1))
+		buf = append(buf, 1)
+		buf = append(buf, tx.Metadata...)
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, 0xffffffff)
+	}
+
+	// Column 10: batch_id (UUID), NULL for records produced without one
+	if batchID := ts.BatchIDs[idx]; batchID != uuid.Nil {
+		buf = binary.BigEndian.AppendUint32(buf, 16)
+		buf = append(buf, batchID[:]...)
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, 0xffffffff)
+	}
+
 	return buf
 }
+
+// Appends a TEXT field, empty strings are written as NULL
+func appendText(buf []byte, s string) []byte {
+	if s == "" {
+		return binary.BigEndian.AppendUint32(buf, 0xffffffff)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
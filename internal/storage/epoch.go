@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Returned by EfficientWriteBatch once another writer has acquired the partition, the batch is not written
+var ErrPartitionFenced = errors.New("partition acquired by another writer")
+
+/*
+** Takes over writing a partition by bumping its epoch, and returns the new epoch to write batches with. Any writer
+** still holding an older epoch has its next batch refused. The row lock waits out a batch the previous writer has
+** in flight, so its commit is never interleaved with the new writer's.
+ */
+func (ts *TransactionStore) AcquirePartition(ctx context.Context, partition int) (int64, error) {
+	const acquire = `UPDATE kafka_offsets SET epoch = epoch + 1, updated_at = NOW() WHERE partition_id = $1 RETURNING epoch`
+	var epoch int64
+	if err := ts.pool.QueryRow(ctx, acquire, partition).Scan(&epoch); err != nil {
+		return 0, fmt.Errorf("failed to acquire partition %d: %v", partition, err)
+	}
+	return epoch, nil
+}
+
+/*
+** Locks the partition's offset row for the rest of the batch and checks the batch may be written. Returns false
+** when every record in it is already committed, which happens when a writer resumes from an offset read before
+** its predecessor's last commit.
+ */
+func (ts *TransactionStore) lockPartition(ctx context.Context, tx pgx.Tx, workerId int, source *EfficientTransactionSource) (bool, error) {
+	const lock = `SELECT epoch, last_offset FROM kafka_offsets WHERE partition_id = $1 FOR UPDATE`
+	var epoch, lastOffset int64
+	if err := tx.QueryRow(ctx, lock, workerId).Scan(&epoch, &lastOffset); err != nil {
+		return false, err
+	}
+	if epoch != source.Epoch {
+		return false, fmt.Errorf("%w: partition %d is at epoch %d, writer holds %d", ErrPartitionFenced, workerId, epoch, source.Epoch)
+	}
+	return source.Offset > lastOffset, nil
+}
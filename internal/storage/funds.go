@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Row locks taken in ID order so concurrent lockers cannot deadlock
+const lockAccountsQuery = `SELECT id FROM accounts WHERE id = ANY($1) ORDER BY id FOR UPDATE`
+
+/*
+** Marks debits in the batch that would take an account below its min_balance. Rows are walked in offset order so
+** earlier transactions consume the headroom first, and a rejected debit leaves it untouched for later ones. Only
+** accounts with a debit in the batch are looked up since credits can never breach the limit. Missing and inactive
+** accounts are left for the merge to reject.
+**
+** Debited accounts are locked until the batch commits and their headroom is read afterwards, so journal legs
+** merged concurrently into the same partition table are always seen.
+ */
+func (ts *TransactionStore) checkFunds(ctx context.Context, tx pgx.Tx, workerId int, source *EfficientTransactionSource) error {
+	clear(source.Reasons[:source.Count])
+
+	debited := make(map[uuid.UUID]struct{})
+	for i := 0; i < source.Count; i++ {
+		t := &source.Txs[i]
+		if t.Amount < 0 && len(t.AccountId) == 16 {
+			debited[uuid.UUID(t.AccountId)] = struct{}{}
+		}
+	}
+	if len(debited) == 0 {
+		return nil
+	}
+
+	accountIDs := make([]uuid.UUID, 0, len(debited))
+	for id := range debited {
+		accountIDs = append(accountIDs, id)
+	}
+
+	if _, err := tx.Exec(ctx, lockAccountsQuery, accountIDs); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, ts.fundsQueries[workerId], accountIDs)
+	if err != nil {
+		return err
+	}
+	headroom := make(map[uuid.UUID]int64, len(accountIDs))
+	currencies := make(map[uuid.UUID]string, len(accountIDs))
+	for rows.Next() {
+		var id uuid.UUID
+		var currency string
+		var available int64
+		if err := rows.Scan(&id, &currency, &available); err != nil {
+			rows.Close()
+			return err
+		}
+		headroom[id] = available
+		currencies[id] = currency
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(headroom) == 0 {
+		return nil
+	}
+
+	// Reason already decided per ID. Redelivered IDs are dropped by the merge and must not consume headroom, and
+	// duplicates within the batch share the outcome of their first occurrence.
+	decided := make(map[uuid.UUID]string)
+	if !source.rewriteIDs {
+		var txIDs []uuid.UUID
+		for i := 0; i < source.Count; i++ {
+			t := &source.Txs[i]
+			if len(t.Id) != 16 || len(t.AccountId) != 16 {
+				continue
+			}
+			if _, ok := headroom[uuid.UUID(t.AccountId)]; ok {
+				txIDs = append(txIDs, uuid.UUID(t.Id))
+			}
+		}
+
+		const existingQuery = `SELECT id FROM transaction_ids WHERE id = ANY($1)`
+		rows, err := tx.Query(ctx, existingQuery, txIDs)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			decided[id] = ""
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < source.Count; i++ {
+		t := &source.Txs[i]
+		if len(t.Id) != 16 || len(t.AccountId) != 16 {
+			continue
+		}
+		account := uuid.UUID(t.AccountId)
+		available, ok := headroom[account]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case t.Currency != "" && t.Currency != currencies[account]:
+			source.Reasons[i] = model.RejectReasonCurrencyMismatch
+		case available+t.Amount < 0:
+			source.Reasons[i] = model.RejectReasonInsufficientFunds
+		}
+
+		// IDs are rewritten while encoding in load test mode, so they are always unique
+		if !source.rewriteIDs {
+			id := uuid.UUID(t.Id)
+			if reason, ok := decided[id]; ok {
+				source.Reasons[i] = reason
+				continue
+			}
+			decided[id] = source.Reasons[i]
+		}
+
+		if source.Reasons[i] == "" {
+			headroom[account] = available + t.Amount
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IdempotencyStore struct {
+	pool *pgxpool.Pool
+}
+
+/*
+** Claims a key for a new request. Returns claimed=true if the caller owns the key and should process the request,
+** otherwise returns the existing record so the caller can replay or reject it. Expired keys and in-flight claims
+** older than staleBefore (the owning request died) are reclaimed.
+ */
+func (is *IdempotencyStore) ClaimIdempotencyKey(ctx context.Context, key string, requestHash []byte, expiresAt time.Time, staleBefore time.Time) (*model.IdempotencyKey, bool, error) {
+	const claimQuery = `
+		INSERT INTO idempotency_keys (key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, NOW(), $3)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+			status_code = NULL,
+			response = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+			OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $4)
+		RETURNING key
+	`
+	var claimedKey string
+	err := is.pool.QueryRow(ctx, claimQuery, key, requestHash, expiresAt, staleBefore).Scan(&claimedKey)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	const getQuery = `
+		SELECT key, request_hash, COALESCE(status_code, 0) AS status_code, response, created_at, expires_at
+		FROM idempotency_keys WHERE key = $1
+	`
+	rows, err := is.pool.Query(ctx, getQuery, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[model.IdempotencyKey])
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &record, false, nil
+}
+
+func (is *IdempotencyStore) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, response []byte) error {
+	const completeQuery = `UPDATE idempotency_keys SET status_code = $2, response = $3 WHERE key = $1`
+	_, err := is.pool.Exec(ctx, completeQuery, key, statusCode, response)
+	return err
+}
+
+func (is *IdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	const releaseQuery = `DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL`
+	_, err := is.pool.Exec(ctx, releaseQuery, key)
+	return err
+}
+
+func (is *IdempotencyStore) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	const purgeQuery = `DELETE FROM idempotency_keys WHERE expires_at < NOW()`
+	tag, err := is.pool.Exec(ctx, purgeQuery)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
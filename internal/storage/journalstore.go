@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	MaxJournalLegs   = 16
+	journalGIDPrefix = "journal:"
+)
+
+var (
+	ErrJournalNoCurrency     = errors.New("journal entry needs a currency")
+	ErrJournalTooFewLegs     = errors.New("journal entry needs at least two legs")
+	ErrJournalTooManyLegs    = fmt.Errorf("journal entry has more than %d legs", MaxJournalLegs)
+	ErrJournalInvalidAccount = errors.New("journal leg has an invalid account ID")
+	ErrJournalZeroAmount     = errors.New("journal leg amount must not be zero")
+	ErrJournalDuplicateLeg   = errors.New("journal entry has more than one leg for the same account")
+	ErrJournalUnbalanced     = errors.New("journal entry legs do not net to zero")
+	ErrJournalLegExists      = errors.New("journal leg already recorded")
+)
+
+/*
+** Checks that a journal entry is balanced double-entry in a single currency: at least two legs on distinct accounts,
+** no zero amounts, and amounts that net to zero without overflowing
+ */
+func ValidateJournalEntry(entry *model.JournalEntry) error {
+	if entry.Currency == "" {
+		return ErrJournalNoCurrency
+	}
+	if len(entry.Legs) < 2 {
+		return ErrJournalTooFewLegs
+	}
+	if len(entry.Legs) > MaxJournalLegs {
+		return ErrJournalTooManyLegs
+	}
+
+	var sum int64
+	for i, leg := range entry.Legs {
+		if leg.AccountID == uuid.Nil {
+			return ErrJournalInvalidAccount
+		}
+		if leg.Amount == 0 {
+			return ErrJournalZeroAmount
+		}
+		for _, other := range entry.Legs[:i] {
+			if other.AccountID == leg.AccountID {
+				return ErrJournalDuplicateLeg
+			}
+		}
+		if (leg.Amount > 0 && sum > math.MaxInt64-leg.Amount) || (leg.Amount < 0 && sum < math.MinInt64-leg.Amount) {
+			return ErrJournalUnbalanced
+		}
+		sum += leg.Amount
+	}
+	if sum != 0 {
+		return ErrJournalUnbalanced
+	}
+
+	return nil
+}
+
+// Leg transaction IDs are derived from the entry, so a redelivered entry can never merge its legs twice
+func JournalLegID(entryID uuid.UUID, index int) uuid.UUID {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(index))
+	return uuid.NewSHA1(entryID, data[:])
+}
+
+// Global identifier of the prepared transaction holding an entry's legs on a shard
+func JournalGID(entryID uuid.UUID, shard int) string {
+	return fmt.Sprintf("%s%s:%d", journalGIDPrefix, entryID, shard)
+}
+
+func ParseJournalGID(gid string) (uuid.UUID, bool) {
+	rest, ok := strings.CutPrefix(gid, journalGIDPrefix)
+	if !ok {
+		return uuid.Nil, false
+	}
+	idStr, _, ok := strings.Cut(rest, ":")
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+type JournalStore struct {
+	pool            *pgxpool.Pool
+	headroomQueries [NumPartitions]string
+	legQueries      [NumPartitions]string
+}
+
+func NewJournalStore(pool *pgxpool.Pool) *JournalStore {
+	js := &JournalStore{
+		pool: pool,
+	}
+
+	for i := range NumPartitions {
+		js.headroomQueries[i] = fmt.Sprintf(`
+		SELECT a.status, a.currency,
+			(a.balance + COALESCE((SELECT SUM(t.amount) FROM transactions_%d t WHERE t.account_id = a.id), 0) - a.min_balance)::BIGINT
+		FROM accounts a
+		WHERE a.id = $1
+	`, i)
+		js.legQueries[i] = fmt.Sprintf(`
+		WITH new_id AS (
+			INSERT INTO transaction_ids (id, partition_id, created_at)
+			VALUES ($1, %[1]d, $4)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id
+		)
+		INSERT INTO transactions_%[1]d (id, account_id, amount, created_at)
+		SELECT id, $2, $3, $4 FROM new_id
+	`, i)
+	}
+
+	return js
+}
+
+/*
+** The part of a journal entry on one shard, held open on a dedicated connection until it is committed or rolled
+** back. Parts spanning several shards are prepared first so they can be committed together.
+ */
+type JournalPart struct {
+	conn     *pgxpool.Conn
+	gid      string
+	prepared bool
+}
+
+/*
+** Opens the part of an entry on this shard: locks the legs' accounts, checks they are active, hold the entry's
+** currency and that debits stay above min_balance, then merges the legs into their partition tables. The home part also records the entry itself.
+** Returns a reject reason instead of a part if any leg is refused, nothing is left open in that case.
+**
+** Accounts are locked before their headroom is read so the statement sees every merge committed before the lock
+** was granted, partition workers lock debited accounts the same way.
+ */
+func (js *JournalStore) BeginJournalPart(ctx context.Context, entry *model.JournalEntry, legs []model.JournalLeg, home bool, gid string) (*JournalPart, string, error) {
+	conn, err := js.pool.Acquire(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	part := &JournalPart{conn: conn, gid: gid}
+
+	if _, err := conn.Exec(ctx, "BEGIN"); err != nil {
+		conn.Release()
+		return nil, "", err
+	}
+
+	reason, err := js.writeJournalPart(ctx, conn, entry, legs, home)
+	if err != nil || reason != "" {
+		if rollbackErr := part.Rollback(ctx); rollbackErr != nil && err == nil {
+			err = rollbackErr
+		}
+		return nil, reason, err
+	}
+
+	return part, "", nil
+}
+
+func (js *JournalStore) writeJournalPart(ctx context.Context, conn *pgxpool.Conn, entry *model.JournalEntry, legs []model.JournalLeg, home bool) (string, error) {
+	if len(legs) > 0 {
+		accountIDs := make([]uuid.UUID, len(legs))
+		for i, leg := range legs {
+			accountIDs[i] = leg.AccountID
+		}
+		if _, err := conn.Exec(ctx, lockAccountsQuery, accountIDs); err != nil {
+			return "", err
+		}
+	}
+
+	for _, leg := range legs {
+		var status, currency string
+		var headroom int64
+		err := conn.QueryRow(ctx, js.headroomQueries[PartitionForAccount(leg.AccountID)], leg.AccountID).Scan(&status, &currency, &headroom)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return model.RejectReasonAccountNotFound, nil
+			}
+			return "", err
+		}
+
+		switch {
+		case status == model.AccountStatusFrozen:
+			return model.RejectReasonAccountFrozen, nil
+		case status == model.AccountStatusClosed:
+			return model.RejectReasonAccountClosed, nil
+		case currency != entry.Currency:
+			return model.RejectReasonCurrencyMismatch, nil
+		case leg.Amount < 0 && headroom+leg.Amount < 0:
+			return model.RejectReasonInsufficientFunds, nil
+		}
+	}
+
+	for _, leg := range legs {
+		tag, err := conn.Exec(ctx, js.legQueries[PartitionForAccount(leg.AccountID)], leg.TransactionID, leg.AccountID, leg.Amount, entry.CreatedAt)
+		if err != nil {
+			return "", err
+		}
+		if tag.RowsAffected() == 0 {
+			return "", ErrJournalLegExists
+		}
+	}
+
+	if home {
+		if err := insertJournalEntry(ctx, conn, entry, model.JournalStatusApplied, ""); err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// Satisfied by both a pooled connection with an open transaction and a pgx.Tx
+type journalWriter interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+func insertJournalEntry(ctx context.Context, conn journalWriter, entry *model.JournalEntry, status string, reason string) error {
+	const entryQuery = `
+		INSERT INTO journal_entries (id, currency, status, reason, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`
+	if _, err := conn.Exec(ctx, entryQuery, entry.ID, entry.Currency, status, reason, entry.CreatedAt); err != nil {
+		return err
+	}
+
+	const legQuery = `
+		INSERT INTO journal_legs (journal_id, leg_index, transaction_id, account_id, amount)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	batch := &pgx.Batch{}
+	for i, leg := range entry.Legs {
+		batch.Queue(legQuery, entry.ID, i, leg.TransactionID, leg.AccountID, leg.Amount)
+	}
+	return conn.SendBatch(ctx, batch).Close()
+}
+
+func (p *JournalPart) Prepare(ctx context.Context) error {
+	if _, err := p.conn.Exec(ctx, fmt.Sprintf("PREPARE TRANSACTION '%s'", p.gid)); err != nil {
+		return err
+	}
+	p.prepared = true
+	return nil
+}
+
+func (p *JournalPart) Commit(ctx context.Context) error {
+	defer p.conn.Release()
+	query := "COMMIT"
+	if p.prepared {
+		query = fmt.Sprintf("COMMIT PREPARED '%s'", p.gid)
+	}
+	_, err := p.conn.Exec(ctx, query)
+	return err
+}
+
+func (p *JournalPart) Rollback(ctx context.Context) error {
+	defer p.conn.Release()
+	query := "ROLLBACK"
+	if p.prepared {
+		query = fmt.Sprintf("ROLLBACK PREPARED '%s'", p.gid)
+	}
+	_, err := p.conn.Exec(ctx, query)
+	return err
+}
+
+// Returns the connection without finishing the part, only valid once it is prepared
+func (p *JournalPart) Release() {
+	p.conn.Release()
+}
+
+/*
+** Records an entry that was refused as a whole, none of its legs are merged. Recording it again is a no-op.
+ */
+func (js *JournalStore) RejectJournalEntry(ctx context.Context, entry *model.JournalEntry, reason string) error {
+	tx, err := js.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM journal_entries WHERE id = $1)`, entry.ID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if err := insertJournalEntry(ctx, tx, entry, model.JournalStatusRejected, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (js *JournalStore) GetJournalEntry(ctx context.Context, id uuid.UUID) (*model.JournalEntry, error) {
+	const entryQuery = `SELECT id, currency, status, COALESCE(reason, '') AS reason, created_at FROM journal_entries WHERE id = $1`
+	rows, err := js.pool.Query(ctx, entryQuery, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := pgx.CollectOneRow(rows, pgx.RowToStructByNameLax[model.JournalEntry])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Entry not found
+		}
+		return nil, err
+	}
+
+	const legsQuery = `SELECT transaction_id, account_id, amount FROM journal_legs WHERE journal_id = $1 ORDER BY leg_index`
+	rows, err = js.pool.Query(ctx, legsQuery, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Legs, err = pgx.CollectRows(rows, pgx.RowToStructByName[model.JournalLeg])
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Prepared journal parts left behind on this shard by a processor that stopped between prepare and commit
+func (js *JournalStore) PreparedJournalParts(ctx context.Context) ([]string, error) {
+	const preparedQuery = `SELECT gid FROM pg_prepared_xacts WHERE database = current_database() AND gid LIKE $1`
+	rows, err := js.pool.Query(ctx, preparedQuery, journalGIDPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (js *JournalStore) FinishPrepared(ctx context.Context, gid string, commit bool) error {
+	query := fmt.Sprintf("ROLLBACK PREPARED '%s'", gid)
+	if commit {
+		query = fmt.Sprintf("COMMIT PREPARED '%s'", gid)
+	}
+	_, err := js.pool.Exec(ctx, query)
+	return err
+}
+
+// Leg timestamps are assigned once when the entry is first processed
+func NewJournalEntry(id uuid.UUID, currency string, legs []model.JournalLeg) *model.JournalEntry {
+	entry := &model.JournalEntry{
+		ID:        id,
+		Currency:  currency,
+		CreatedAt: time.Now().UTC(),
+		Legs:      legs,
+	}
+	for i := range entry.Legs {
+		entry.Legs[i].TransactionID = JournalLegID(id, i)
+	}
+	return entry
+}
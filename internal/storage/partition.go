@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Number of Kafka partitions, each backed by its own staging_N and transactions_N tables
+const NumPartitions = 64
+
+// Keyed records are hashed statelessly, so one partitioner is safe to share
+var accountPartitioner = kgo.StickyKeyPartitioner(nil).ForTopic("transactions")
+
+// PartitionForAccount returns the partition an account's transactions are produced to, matching the murmur2 key
+// hashing of the API's producer
+func PartitionForAccount(id uuid.UUID) int32 {
+	return PartitionForKey(id[:])
+}
+
+// PartitionForKey returns the transactions partition for any record key
+func PartitionForKey(key []byte) int32 {
+	return int32(accountPartitioner.Partition(&kgo.Record{Key: key}, NumPartitions))
+}
+
+// ShardForPartition returns the database shard whose worker owns a partition, workers consume contiguous ranges
+// such as 0-31 and 32-63 for two shards
+func ShardForPartition(partition int32, numShards int) int {
+	return int(partition) * numShards / NumPartitions
+}
@@ -11,14 +11,20 @@ type PostgresStore struct {
 	pool             *pgxpool.Pool
 	accountStore     *AccountStore
 	transactionStore *TransactionStore
+	idempotencyStore *IdempotencyStore
+	journalStore     *JournalStore
+	batchStore       *BatchStore
 }
 
 func NewPostgresStore(log *slog.Logger, pool *pgxpool.Pool) *PostgresStore {
 	return &PostgresStore{
 		log:              log,
 		pool:             pool,
-		accountStore:     &AccountStore{pool: pool},
+		accountStore:     NewAccountStore(pool),
 		transactionStore: NewTransactionStore(pool),
+		idempotencyStore: &IdempotencyStore{pool: pool},
+		journalStore:     NewJournalStore(pool),
+		batchStore:       NewBatchStore(pool),
 	}
 }
 
@@ -33,3 +39,15 @@ func (ps *PostgresStore) Accounts() *AccountStore {
 func (ps *PostgresStore) Transactions() *TransactionStore {
 	return ps.transactionStore
 }
+
+func (ps *PostgresStore) Idempotency() *IdempotencyStore {
+	return ps.idempotencyStore
+}
+
+func (ps *PostgresStore) Journal() *JournalStore {
+	return ps.journalStore
+}
+
+func (ps *PostgresStore) Batches() *BatchStore {
+	return ps.batchStore
+}
@@ -18,7 +18,7 @@ func (ts *TransactionStore) WriteBatch(ctx context.Context, batch []*kgo.Record)
 	}
 	defer tx.Rollback(ctx)
 
-	source := NewTransactionSource(batch)
+	source := NewTransactionSource(batch, ts.loadTest)
 
 	_, err = tx.CopyFrom(ctx, pgx.Identifier{"staging"}, []string{"id", "account_id", "amount", "created_at"}, source)
 	if err != nil {
@@ -1,9 +1,15 @@
 package storage
 
 import (
+	"bytes"
 	"context"
-	"encoding/binary"
+	"errors"
+	"hash/fnv"
 	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/google/uuid"
@@ -29,10 +35,15 @@ func NewShardedStore(log *slog.Logger, pools []*pgxpool.Pool) *ShardedStore {
 }
 
 func (s *ShardedStore) getShard(uid uuid.UUID) *PostgresStore {
-	// Entropy from last 8 bytes
-	val := binary.BigEndian.Uint64(uid[8:16])
-	shardKey := val % uint64(s.numShards)
-	return s.shards[shardKey]
+	// Accounts live on the shard whose worker writes behind their partition
+	partition := PartitionForAccount(uid)
+	return s.shards[ShardForPartition(partition, s.numShards)]
+}
+
+func (s *ShardedStore) getShardForKey(key string) *PostgresStore {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(s.numShards)]
 }
 
 func (s *ShardedStore) GetAccount(ctx context.Context, uid uuid.UUID) (*model.Account, error) {
@@ -40,7 +51,169 @@ func (s *ShardedStore) GetAccount(ctx context.Context, uid uuid.UUID) (*model.Ac
 	return shard.Accounts().GetAccount(ctx, uid)
 }
 
-func (s *ShardedStore) GetTransaction(ctx context.Context, uid uuid.UUID) (*model.Transaction, error) {
+func (s *ShardedStore) CreateAccount(ctx context.Context, uid uuid.UUID, currency string, minBalance int64) (*model.Account, error) {
 	shard := s.getShard(uid)
-	return shard.Transactions().GetTransaction(ctx, uid)
+	return shard.Accounts().CreateAccount(ctx, uid, currency, minBalance)
+}
+
+/*
+** Lists accounts ordered by ID across every shard, each shard returns its first page after the cursor and the
+** merged result is trimmed back to the limit
+ */
+func (s *ShardedStore) ListAccounts(ctx context.Context, after uuid.UUID, limit int) ([]model.Account, error) {
+	var accounts []model.Account
+	for _, shard := range s.shards {
+		page, err := shard.Accounts().ListAccounts(ctx, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, page...)
+	}
+
+	slices.SortFunc(accounts, func(a, b model.Account) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	if len(accounts) > limit {
+		accounts = accounts[:limit]
+	}
+	return accounts, nil
+}
+
+func (s *ShardedStore) UpdateAccount(ctx context.Context, uid uuid.UUID, status string, minBalance *int64) (*model.Account, error) {
+	shard := s.getShard(uid)
+	return shard.Accounts().UpdateAccount(ctx, uid, status, minBalance)
+}
+
+func (s *ShardedStore) ListAccountTransactions(ctx context.Context, accountID uuid.UUID, filter model.TransactionFilter) ([]model.Transaction, error) {
+	shard := s.getShard(accountID)
+	return shard.Transactions().ListAccountTransactions(ctx, accountID, filter)
+}
+
+/*
+** Transactions live on the shard that owns their account's partition, which the ID alone does not tell us, so every
+** shard's transaction_ids ledger is probed at once. The unpruned archive probe only runs once all of them miss.
+ */
+func (s *ShardedStore) GetTransaction(ctx context.Context, uid uuid.UUID) (*model.Transaction, error) {
+	tx, err := s.probeShards(ctx, uid, (*TransactionStore).GetLedgerTransaction)
+	if err != nil || tx != nil {
+		return tx, err
+	}
+	return s.probeShards(ctx, uid, (*TransactionStore).GetArchivedTransaction)
+}
+
+// Runs a lookup on every shard concurrently and returns the first transaction found, in shard order
+func (s *ShardedStore) probeShards(ctx context.Context, uid uuid.UUID, lookup func(*TransactionStore, context.Context, uuid.UUID) (*model.Transaction, error)) (*model.Transaction, error) {
+	found := make([]*model.Transaction, len(s.shards))
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found[i], errs[i] = lookup(shard.Transactions(), ctx, uid)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for _, tx := range found {
+		if tx != nil {
+			return tx, nil
+		}
+	}
+	return nil, nil
+}
+
+// A reference may be reused across accounts on different shards, so every shard is searched and the results merged
+func (s *ShardedStore) FindTransactionsByExternalRef(ctx context.Context, ref string, limit int) ([]model.Transaction, error) {
+	var transactions []model.Transaction
+	for _, shard := range s.shards {
+		found, err := shard.Transactions().FindTransactionsByExternalRef(ctx, ref, limit)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, found...)
+	}
+
+	sortNewestFirst(transactions)
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
+}
+
+// Each partition's offset is kept on the shard whose worker consumes it
+func (s *ShardedStore) CommittedOffsets(ctx context.Context, partitions []int32) (map[int32]int64, error) {
+	byShard := make(map[int][]int32)
+	for _, p := range partitions {
+		shard := ShardForPartition(p, s.numShards)
+		byShard[shard] = append(byShard[shard], p)
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for shard, parts := range byShard {
+		found, err := s.shards[shard].Transactions().CommittedOffsets(ctx, parts)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(offsets, found)
+	}
+	return offsets, nil
+}
+
+func (s *ShardedStore) CreateBatch(ctx context.Context, id uuid.UUID, produced int) error {
+	return s.getShard(id).Batches().CreateBatch(ctx, id, produced)
+}
+
+/*
+** A batch is recorded on the shard its ID maps to, while its records are counted by the workers of every shard they
+** were produced to, so progress is summed across all shards
+ */
+func (s *ShardedStore) GetBatch(ctx context.Context, id uuid.UUID) (*model.Batch, error) {
+	batch, err := s.getShard(id).Batches().GetBatch(ctx, id)
+	if err != nil || batch == nil {
+		return nil, err
+	}
+
+	for _, shard := range s.shards {
+		if err := shard.Batches().AddBatchProgress(ctx, batch); err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+// Journal entries are recorded on the shard their ID maps to, the same way accounts are
+func (s *ShardedStore) GetJournalEntry(ctx context.Context, id uuid.UUID) (*model.JournalEntry, error) {
+	shard := s.getShard(id)
+	return shard.Journal().GetJournalEntry(ctx, id)
+}
+
+func (s *ShardedStore) ClaimIdempotencyKey(ctx context.Context, key string, requestHash []byte, expiresAt time.Time, staleBefore time.Time) (*model.IdempotencyKey, bool, error) {
+	shard := s.getShardForKey(key)
+	return shard.Idempotency().ClaimIdempotencyKey(ctx, key, requestHash, expiresAt, staleBefore)
+}
+
+func (s *ShardedStore) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, response []byte) error {
+	shard := s.getShardForKey(key)
+	return shard.Idempotency().CompleteIdempotencyKey(ctx, key, statusCode, response)
+}
+
+func (s *ShardedStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	shard := s.getShardForKey(key)
+	return shard.Idempotency().ReleaseIdempotencyKey(ctx, key)
+}
+
+func (s *ShardedStore) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	var total int64
+	for _, shard := range s.shards {
+		n, err := shard.Idempotency().PurgeExpiredIdempotencyKeys(ctx)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
 }
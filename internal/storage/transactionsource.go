@@ -15,15 +15,18 @@ type TransactionSource struct {
 	idx     int
 	pb      pb.Transaction
 	offsets map[int32]int64
-	salt    uint32
+
+	rewriteIDs bool // Load testing only, replayed batches would otherwise be deduplicated
+	salt       uint32
 }
 
-func NewTransactionSource(records []*kgo.Record) *TransactionSource {
+func NewTransactionSource(records []*kgo.Record, rewriteIDs bool) *TransactionSource {
 	return &TransactionSource{
-		records: records,
-		idx:     -1,
-		offsets: make(map[int32]int64),
-		salt:    uint32(time.Now().UnixNano()),
+		records:    records,
+		idx:        -1,
+		offsets:    make(map[int32]int64),
+		rewriteIDs: rewriteIDs,
+		salt:       uint32(time.Now().UnixNano()),
 	}
 }
 
@@ -41,8 +44,9 @@ func (ts *TransactionSource) Values() ([]any, error) {
 
 	ts.offsets[record.Partition] = record.Offset
 
-	// LOAD TESTING modify ID to avoid collisions
-	binary.BigEndian.PutUint32(ts.pb.Id[0:4], atomic.AddUint32(&ts.salt, 1))
+	if ts.rewriteIDs {
+		binary.BigEndian.PutUint32(ts.pb.Id[0:4], atomic.AddUint32(&ts.salt, 1))
+	}
 
 	return []any{
 		ts.pb.Id,
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/google/uuid"
@@ -11,11 +14,18 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Columns shared by transactions_N, transactions_history and rejected_transactions, scanned into model.Transaction
+const transactionColumns = `id, account_id, amount, created_at, occurred_at, COALESCE(external_ref, '') AS external_ref, metadata`
+
 type TransactionStore struct {
 	pool            *pgxpool.Pool
 	copyQueries     [64]string
 	mergeQueries    [64]string
 	truncateQueries [64]string
+	lookupQueries   [64]string
+	historyQueries  [64]string
+	fundsQueries    [64]string
+	loadTest        bool
 }
 
 func NewTransactionStore(pool *pgxpool.Pool) *TransactionStore {
@@ -24,21 +34,140 @@ func NewTransactionStore(pool *pgxpool.Pool) *TransactionStore {
 	}
 
 	for i := range 64 {
-		ts.copyQueries[i] = fmt.Sprintf(`COPY staging_%d FROM STDIN WITH (FORMAT BINARY)`, i)
+		ts.copyQueries[i] = fmt.Sprintf(`COPY staging_%d (id, account_id, amount, created_at, reject_reason, currency, occurred_at, external_ref, metadata, batch_id) FROM STDIN WITH (FORMAT BINARY)`, i)
+		// Only IDs newly recorded in the transaction_ids ledger are merged. The ledger outlives the write-behind of
+		// transactions_N, so redelivered records are rejected for the whole retention window. Transactions against
+		// missing, frozen or closed accounts, sent in another currency than the account holds, or that failed the
+		// funds check, are quarantined in rejected_transactions instead of being applied. Each batch's progress in
+		// the partition is counted in the same statement.
 		ts.mergeQueries[i] = fmt.Sprintf(`
-		INSERT INTO transactions_%d (id, account_id, amount, created_at)
-		SELECT id, account_id, amount, created_at FROM staging_%d
-		ON CONFLICT (id) DO NOTHING
-	`, i, i)
+		WITH new_ids AS (
+			INSERT INTO transaction_ids (id, partition_id, created_at, external_ref)
+			SELECT DISTINCT ON (id) id, %[1]d, created_at, external_ref FROM staging_%[1]d
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id
+		), classified AS (
+			SELECT DISTINCT ON (s.id) s.id, s.account_id, s.amount, s.created_at, s.currency,
+				s.occurred_at, s.external_ref, s.metadata, s.batch_id,
+				CASE
+					WHEN a.id IS NULL THEN '%[2]s'
+					WHEN a.status = '%[5]s' THEN '%[3]s'
+					WHEN a.status = '%[6]s' THEN '%[4]s'
+					WHEN s.currency <> a.currency THEN '%[7]s'
+					ELSE s.reject_reason
+				END AS reason
+			FROM staging_%[1]d s
+			JOIN new_ids USING (id)
+			LEFT JOIN accounts a ON a.id = s.account_id
+		), rejected AS (
+			INSERT INTO rejected_transactions (id, account_id, amount, created_at, partition_id, reason, currency,
+				occurred_at, external_ref, metadata)
+			SELECT id, account_id, amount, created_at, %[1]d, reason, currency, occurred_at, external_ref, metadata
+			FROM classified WHERE reason IS NOT NULL
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id
+		), merged AS (
+			INSERT INTO transactions_%[1]d (id, account_id, amount, created_at, occurred_at, external_ref, metadata, batch_id)
+			SELECT id, account_id, amount, created_at, occurred_at, external_ref, metadata, batch_id FROM classified WHERE reason IS NULL
+			ON CONFLICT (id) DO NOTHING
+			RETURNING batch_id
+		), progress AS (
+			INSERT INTO batch_progress AS p (batch_id, partition_id, staged, merged, rejected, updated_at)
+			SELECT s.batch_id, %[1]d, s.n, COALESCE(m.n, 0), COALESCE(r.n, 0), NOW()
+			FROM (SELECT batch_id, COUNT(*) AS n FROM staging_%[1]d WHERE batch_id IS NOT NULL GROUP BY batch_id) s
+			LEFT JOIN (SELECT batch_id, COUNT(*) AS n FROM merged GROUP BY batch_id) m USING (batch_id)
+			LEFT JOIN (SELECT c.batch_id, COUNT(*) AS n FROM rejected JOIN classified c USING (id) GROUP BY c.batch_id) r USING (batch_id)
+			ON CONFLICT (batch_id, partition_id) DO UPDATE SET
+				staged = p.staged + EXCLUDED.staged,
+				merged = p.merged + EXCLUDED.merged,
+				rejected = p.rejected + EXCLUDED.rejected,
+				updated_at = EXCLUDED.updated_at
+		)
+		SELECT (SELECT COUNT(*) FROM merged), (SELECT COUNT(*) FROM rejected)
+	`, i, model.RejectReasonAccountNotFound, model.RejectReasonAccountFrozen, model.RejectReasonAccountClosed,
+			model.AccountStatusFrozen, model.AccountStatusClosed, model.RejectReasonCurrencyMismatch)
 		ts.truncateQueries[i] = fmt.Sprintf(`TRUNCATE TABLE staging_%d`, i)
+		// Headroom above min_balance including merged rows not yet written behind. Write-behind moves them into the
+		// balance atomically, so the statement's snapshot never double counts.
+		ts.fundsQueries[i] = fmt.Sprintf(`
+		SELECT a.id, a.currency,
+			(a.balance + COALESCE((SELECT SUM(t.amount) FROM transactions_%[1]d t WHERE t.account_id = a.id), 0) - a.min_balance)::BIGINT
+		FROM accounts a
+		WHERE a.id = ANY($1) AND a.status = '%[2]s'
+	`, i, model.AccountStatusActive)
+		// Write-behind moves rows from transactions_N to transactions_history atomically, so a single statement
+		// always finds a merged transaction in exactly one of them
+		ts.lookupQueries[i] = fmt.Sprintf(`
+		SELECT %[5]s, '%[2]s' AS stage, '' AS reason FROM transactions_%[1]d WHERE id = $1
+		UNION ALL
+		SELECT %[5]s, '%[3]s' AS stage, '' AS reason FROM transactions_history WHERE id = $1 AND created_at = $2
+		UNION ALL
+		SELECT %[5]s, '%[4]s' AS stage, reason FROM rejected_transactions WHERE id = $1
+		LIMIT 1
+	`, i, model.TransactionStageMerged, model.TransactionStageArchived, model.TransactionStageRejected, transactionColumns)
+		// Each side walks its (account_id, created_at, id) index from the cursor, so a page never reads more than
+		// limit rows from either table
+		ts.historyQueries[i] = fmt.Sprintf(`
+		(
+			SELECT %[4]s, '%[2]s' AS stage, '' AS reason FROM transactions_%[1]d
+			WHERE account_id = $1 AND created_at >= $2 AND created_at < $3 AND (created_at, id) < ($4, $5)
+			ORDER BY created_at DESC, id DESC
+			LIMIT $6
+		)
+		UNION ALL
+		(
+			SELECT %[4]s, '%[3]s' AS stage, '' AS reason FROM transactions_history
+			WHERE account_id = $1 AND created_at >= $2 AND created_at < $3 AND (created_at, id) < ($4, $5)
+			ORDER BY created_at DESC, id DESC
+			LIMIT $6
+		)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $6
+	`, i, model.TransactionStageMerged, model.TransactionStageArchived, transactionColumns)
 	}
 
 	return ts
 }
 
+/*
+** Load test mode rewrites the first 4 bytes of every transaction ID before it is persisted, so generator batches can
+** be replayed without being deduplicated. IDs are persisted exactly as sent otherwise.
+ */
+func (ts *TransactionStore) SetLoadTestMode(enabled bool) {
+	ts.loadTest = enabled
+}
+
+func (ts *TransactionStore) LoadTestMode() bool {
+	return ts.loadTest
+}
+
+// Looks up a transaction by ID, through the ledger first and the archive for IDs past the dedup retention window
 func (ts *TransactionStore) GetTransaction(ctx context.Context, id uuid.UUID) (*model.Transaction, error) {
-	const getTransactionQuery = `SELECT id, account_id, amount, transaction_type, created_at FROM transactions WHERE id = $1`
-	rows, err := ts.pool.Query(ctx, getTransactionQuery, id)
+	tx, err := ts.GetLedgerTransaction(ctx, id)
+	if err != nil || tx != nil {
+		return tx, err
+	}
+	return ts.GetArchivedTransaction(ctx, id)
+}
+
+/*
+** Looks up a transaction through the transaction_ids ledger, which routes the lookup to the partition table it was
+** merged into, and whose created_at prunes transactions_history to a single daily partition. Returns nil for IDs
+** not in the ledger.
+ */
+func (ts *TransactionStore) GetLedgerTransaction(ctx context.Context, id uuid.UUID) (*model.Transaction, error) {
+	var partitionID int16
+	var createdAt time.Time
+	const routeQuery = `SELECT partition_id, created_at FROM transaction_ids WHERE id = $1`
+	err := ts.pool.QueryRow(ctx, routeQuery, id).Scan(&partitionID, &createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := ts.pool.Query(ctx, ts.lookupQueries[partitionID], id, createdAt)
 	if err != nil {
 		return nil, err
 	}
@@ -53,3 +182,159 @@ func (ts *TransactionStore) GetTransaction(ctx context.Context, id uuid.UUID) (*
 
 	return &tx, nil
 }
+
+/*
+** Looks up a transaction whose ID has left the ledger. Without its created_at the archive cannot be pruned, so every
+** daily partition of transactions_history is probed, and rejected transactions are kept past retention too.
+ */
+func (ts *TransactionStore) GetArchivedTransaction(ctx context.Context, id uuid.UUID) (*model.Transaction, error) {
+	getArchivedQuery := fmt.Sprintf(`
+		SELECT %[1]s, '%[2]s' AS stage, '' AS reason FROM transactions_history WHERE id = $1
+		UNION ALL
+		SELECT %[1]s, '%[3]s' AS stage, reason FROM rejected_transactions WHERE id = $1
+		LIMIT 1
+	`, transactionColumns, model.TransactionStageArchived, model.TransactionStageRejected)
+	rows, err := ts.pool.Query(ctx, getArchivedQuery, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[model.Transaction])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Transaction not found
+		}
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+var (
+	minTime = time.Unix(0, 0).UTC()
+	maxTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	maxUUID = uuid.UUID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+/*
+** Lists an account's merged and archived transactions newest first, using keyset pagination on (created_at, id)
+ */
+func (ts *TransactionStore) ListAccountTransactions(ctx context.Context, accountID uuid.UUID, filter model.TransactionFilter) ([]model.Transaction, error) {
+	from, to := filter.From, filter.To
+	if from.IsZero() {
+		from = minTime
+	}
+	if to.IsZero() {
+		to = maxTime
+	}
+
+	// Without a cursor start just past the newest row the time range allows
+	afterCreatedAt, afterID := to, maxUUID
+	if filter.After != nil {
+		afterCreatedAt, afterID = filter.After.CreatedAt, filter.After.ID
+	}
+
+	query := ts.historyQueries[PartitionForAccount(accountID)]
+	rows, err := ts.pool.Query(ctx, query, accountID, from, to, afterCreatedAt, afterID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[model.Transaction])
+}
+
+/*
+** Finds transactions carrying an upstream reference, newest first. References still in the transaction_ids ledger are
+** routed like GetTransaction, and archived or rejected transactions past the retention window are found through the
+** external_ref indexes on transactions_history and rejected_transactions.
+ */
+func (ts *TransactionStore) FindTransactionsByExternalRef(ctx context.Context, ref string, limit int) ([]model.Transaction, error) {
+	const routeQuery = `SELECT id, partition_id, created_at FROM transaction_ids WHERE external_ref = $1 ORDER BY created_at DESC LIMIT $2`
+	rows, err := ts.pool.Query(ctx, routeQuery, ref, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	type route struct {
+		ID          uuid.UUID `db:"id"`
+		PartitionID int16     `db:"partition_id"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+	routes, err := pgx.CollectRows(rows, pgx.RowToStructByName[route])
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []model.Transaction
+	seen := make(map[uuid.UUID]struct{}, len(routes))
+	for _, r := range routes {
+		rows, err := ts.pool.Query(ctx, ts.lookupQueries[r.PartitionID], r.ID, r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[model.Transaction])
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue // Still in staging, or the merge has not committed
+			}
+			return nil, err
+		}
+		transactions = append(transactions, tx)
+		seen[tx.ID] = struct{}{}
+	}
+
+	archivedQuery := fmt.Sprintf(`
+		(SELECT %[1]s, '%[2]s' AS stage, '' AS reason FROM transactions_history WHERE external_ref = $1 ORDER BY created_at DESC LIMIT $2)
+		UNION ALL
+		(SELECT %[1]s, '%[3]s' AS stage, reason FROM rejected_transactions WHERE external_ref = $1 ORDER BY created_at DESC LIMIT $2)
+	`, transactionColumns, model.TransactionStageArchived, model.TransactionStageRejected)
+	rows, err = ts.pool.Query(ctx, archivedQuery, ref, limit)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := pgx.CollectRows(rows, pgx.RowToStructByName[model.Transaction])
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range archived {
+		if _, ok := seen[tx.ID]; !ok {
+			transactions = append(transactions, tx)
+		}
+	}
+
+	sortNewestFirst(transactions)
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
+}
+
+// Returns the offset of the last record each partition's worker has committed, -1 for partitions not yet consumed
+func (ts *TransactionStore) CommittedOffsets(ctx context.Context, partitions []int32) (map[int32]int64, error) {
+	const query = `SELECT partition_id, last_offset FROM kafka_offsets WHERE partition_id = ANY($1)`
+	rows, err := ts.pool.Query(ctx, query, partitions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offsets := make(map[int32]int64, len(partitions))
+	for rows.Next() {
+		var partitionID int32
+		var offset int64
+		if err := rows.Scan(&partitionID, &offset); err != nil {
+			return nil, err
+		}
+		offsets[partitionID] = offset
+	}
+	return offsets, rows.Err()
+}
+
+func sortNewestFirst(transactions []model.Transaction) {
+	slices.SortFunc(transactions, func(a, b model.Transaction) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(b.ID[:], a.ID[:])
+	})
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/storage"
+)
+
+/*
+** Bounds on the batches the coordinator hands each partition's writer. A batch is flushed as soon as it reaches
+** MaxRecords, before a record would take its values past MaxBytes, or once its first record has waited Linger.
+** MaxBytes is also the size of each slab's byte buffer, so the values of a batch never overflow it.
+ */
+type BatchConfig struct {
+	MaxRecords int
+	MaxBytes   int
+	Linger     time.Duration // 0 flushes every batch at the end of the poll that filled it
+}
+
+// The previous fixed sizes, 50,000 records of about 64 bytes flushed after every poll
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{
+		MaxRecords: 50000,
+		MaxBytes:   50000 * 64,
+		Linger:     0,
+	}
+}
+
+func (b BatchConfig) Validate() error {
+	if b.MaxRecords <= 0 || b.MaxRecords > storage.MaxSourceRecords {
+		return fmt.Errorf("batch max records must be between 1 and %d, got %d", storage.MaxSourceRecords, b.MaxRecords)
+	}
+	if b.MaxBytes <= 0 {
+		return fmt.Errorf("batch max bytes must be positive, got %d", b.MaxBytes)
+	}
+	if b.Linger < 0 {
+		return fmt.Errorf("batch linger must not be negative, got %v", b.Linger)
+	}
+	return nil
+}
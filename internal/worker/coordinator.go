@@ -2,106 +2,564 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/alexmcook/transaction-ledger/internal/storage"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+const (
+	writeBehindInterval = 2 * time.Second
+
+	// How long a revoked partition's writer may take to finish its buffered batches before the rebalance goes on
+	revokeTimeout = 30 * time.Second
+
+	// Backoff between attempts to rebuild a failed Kafka client
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// Reasons a batch is flushed, the label of worker_batches_flushed_total
+const (
+	flushRecords = "records"
+	flushBytes   = "bytes"
+	flushLinger  = "linger"
+	flushPoll    = "poll"  // End of a poll with no linger configured
+	flushDrain   = "drain" // Partition revoked, client reconnecting or worker stopping
+)
+
+// Values of the worker_kafka_client_state gauge
+const (
+	clientConnected    = 0
+	clientReconnecting = 1
+)
+
 type Coordinator struct {
-	log         *slog.Logger
-	client      *kgo.Client
-	workers     []*MultiWriter
-	writeBehind *WriteBehindWorker
-}
+	log          *slog.Logger
+	opts         []kgo.Opt // Client options without the consume options, kept to rebuild the client
+	group        string    // Empty for a static range
+	minPart      int
+	maxPart      int
+	batch        BatchConfig
+	slabs        *sync.Pool
+	stores       []*storage.PostgresStore // One per shard, a static range has a single store
+	writeBehinds []*WriteBehindWorker     // One per store
+	workerCtx    context.Context          // Set before the client exists, a group may assign partitions before Run
+	shutdown     context.Context          // Cancelled by Stop, ends writers still waiting to acquire their partition
+	stopping     context.CancelFunc
+	backoff      time.Duration // Waited before the next reconnect, grows while fetches keep failing, only Run uses it
 
-func NewCoordinator(ctx context.Context, minPart int, maxPart int, log *slog.Logger, db *storage.PostgresStore, client *kgo.Client, pool *pgxpool.Pool) *Coordinator {
-	numWorkers := maxPart - minPart + 1
+	mu           sync.Mutex
+	client       *kgo.Client          // Replaced by Run when the client fails, only Run reads it without mu
+	workers      []*MultiWriter       // Indexed by partition, nil for partitions this worker does not own
+	startOffsets map[int32]kgo.Offset // Loaded from kafka_offsets as a group assigns partitions
+	activeSlabs  []*RecordBatch       // Indexed by partition, batches still filling or lingering
+}
 
+/*
+** Consumes a fixed range of partitions, starting each from the offset after the last one committed to
+** kafka_offsets. The coordinator creates its client from opts and rebuilds it the same way if it fails.
+ */
+func NewCoordinator(ctx context.Context, minPart int, maxPart int, log *slog.Logger, db *storage.PostgresStore, pool *pgxpool.Pool, batch BatchConfig, opts ...kgo.Opt) (*Coordinator, error) {
+	if err := batch.Validate(); err != nil {
+		return nil, err
+	}
 	c := &Coordinator{
-		log:     log,
-		client:  client,
-		workers: make([]*MultiWriter, numWorkers),
-		writeBehind: NewWriteBehindWorker(
+		log:         log,
+		opts:        opts,
+		batch:       batch,
+		slabs:       newRecordsPool(batch),
+		minPart:     minPart,
+		maxPart:     maxPart,
+		stores:      []*storage.PostgresStore{db},
+		workerCtx:   context.WithoutCancel(ctx),
+		workers:     make([]*MultiWriter, maxPart+1),
+		activeSlabs: make([]*RecordBatch, maxPart+1),
+		writeBehinds: []*WriteBehindWorker{NewWriteBehindWorker(
 			log,
 			pool,
 			minPart,
 			maxPart,
-		),
+			writeBehindInterval,
+		)},
 	}
 
-	for i := range c.workers {
-		c.workers[i] = NewMultiWriter(minPart+i, log, db)
+	c.shutdown, c.stopping = context.WithCancel(c.workerCtx)
+
+	client, err := c.newClient(ctx)
+	if err != nil {
+		return nil, err
 	}
+	c.client = client
 
-	return c
+	c.warnLoadTestMode()
+	return c, nil
 }
 
-func (c *Coordinator) Run(ctx context.Context) error {
-	workerCtx := context.WithoutCancel(ctx)
-	for _, w := range c.workers {
-		w.Start(workerCtx)
+/*
+** Joins a consumer group on the transactions topic instead of consuming a fixed range. The broker's
+** cooperative-sticky balancer spreads partitions over the members, so any partition may be assigned and the
+** worker needs a store for every shard. Offsets stay in kafka_offsets rather than the group: they are loaded as
+** partitions are assigned and fetching starts from them. Rebalances wait until a poll's records are dispatched,
+** and a revoked partition's writer finishes its buffered batches before the partition moves, so the next owner
+** starts exactly where this one stopped.
+ */
+func NewGroupCoordinator(ctx context.Context, log *slog.Logger, group string, stores []*storage.PostgresStore, pools []*pgxpool.Pool, batch BatchConfig, opts ...kgo.Opt) (*Coordinator, error) {
+	if err := batch.Validate(); err != nil {
+		return nil, err
+	}
+	c := &Coordinator{
+		log:          log,
+		opts:         opts,
+		batch:        batch,
+		slabs:        newRecordsPool(batch),
+		group:        group,
+		stores:       stores,
+		workerCtx:    context.WithoutCancel(ctx),
+		workers:      make([]*MultiWriter, storage.NumPartitions),
+		activeSlabs:  make([]*RecordBatch, storage.NumPartitions),
+		startOffsets: make(map[int32]kgo.Offset),
+		writeBehinds: make([]*WriteBehindWorker, len(pools)),
+	}
+	for i, pool := range pools {
+		c.writeBehinds[i] = NewWriteBehindWorker(log, pool, 0, -1, writeBehindInterval)
 	}
 
-	c.writeBehind.Start(workerCtx)
+	c.shutdown, c.stopping = context.WithCancel(c.workerCtx)
 
-	numWorkers := len(c.workers)
-	activeSlabs := make([]*RecordBatch, numWorkers)
+	client, err := c.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+
+	c.warnLoadTestMode()
+	return c, nil
+}
 
-	for i := range activeSlabs {
-		activeSlabs[i] = recordsPool.Get().(*RecordBatch)
-		activeSlabs[i].Reset()
+/*
+** Creates a client from the coordinator's options. A group client joins the group once polled, a static client
+** consumes the range from the offsets in kafka_offsets, loaded now so a rebuilt client resumes after the last
+** batch the drained writers committed.
+ */
+func (c *Coordinator) newClient(ctx context.Context) (*kgo.Client, error) {
+	opts := slices.Clone(c.opts)
+	if c.group != "" {
+		opts = append(opts,
+			kgo.ConsumerGroup(c.group),
+			kgo.ConsumeTopics("transactions"),
+			kgo.Balancers(kgo.CooperativeStickyBalancer()),
+			kgo.DisableAutoCommit(),
+			kgo.BlockRebalanceOnPoll(),
+			kgo.OnPartitionsAssigned(c.assigned),
+			kgo.OnPartitionsRevoked(c.revoked),
+			kgo.OnPartitionsLost(c.revoked),
+			kgo.AdjustFetchOffsetsFn(c.adjustOffsets),
+		)
+	} else {
+		partitions := make([]int32, 0, c.maxPart-c.minPart+1)
+		for p := c.minPart; p <= c.maxPart; p++ {
+			partitions = append(partitions, int32(p))
+		}
+		offsets, err := c.loadOffsets(ctx, partitions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get partition offsets: %v", err)
+		}
+		opts = append(opts, kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{"transactions": offsets}))
 	}
 
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create broker client: %v", err)
+	}
+	return client, nil
+}
+
+// Returns the offset after the last one committed for each partition, or the start of partitions not yet consumed
+func (c *Coordinator) loadOffsets(ctx context.Context, partitions []int32) (map[int32]kgo.Offset, error) {
+	byShard := make(map[int][]int32)
+	for _, p := range partitions {
+		_, shard := c.storeFor(p)
+		byShard[shard] = append(byShard[shard], p)
+	}
+
+	offsets := make(map[int32]kgo.Offset, len(partitions))
+	for shard, ps := range byShard {
+		committed, err := c.stores[shard].Transactions().CommittedOffsets(ctx, ps)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps {
+			if o, ok := committed[p]; ok {
+				offsets[p] = kgo.NewOffset().At(o + 1)
+			} else {
+				offsets[p] = kgo.NewOffset().AtStart()
+			}
+		}
+	}
+	return offsets, nil
+}
+
+/*
+** Creates and starts a writer for every partition of a static range. Starting waits for each partition to be
+** acquired, which takes as long as the database is down, so mu is not held until the writers are published.
+** Returns an error only once ctx is done or Stop has been called.
+ */
+func (c *Coordinator) startWriters(ctx context.Context) error {
+	acquireCtx, cancel := c.acquireContext(ctx)
+	defer cancel()
+
+	writers := make([]*MultiWriter, 0, c.maxPart-c.minPart+1)
+	for i := c.minPart; i <= c.maxPart; i++ {
+		w := NewMultiWriter(i, c.log, c.stores[0], c.client, c.slabs)
+		if err := w.Start(acquireCtx); err != nil {
+			c.stopWriters(writers)
+			return err
+		}
+		writers = append(writers, w)
+	}
+	return c.publishWriters(writers)
+}
+
+// Writers acquire their partitions until ctx is done or Stop is called, whichever is first
+func (c *Coordinator) acquireContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	acquireCtx, cancel := context.WithCancel(c.shutdown)
+	stop := context.AfterFunc(ctx, cancel)
+	return acquireCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+/*
+** Makes started writers visible to route and updates the write-behind's partitions. Stop may have run while they
+** were acquiring, it has already stopped the published writers, so these are stopped instead of published.
+ */
+func (c *Coordinator) publishWriters(writers []*MultiWriter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.shutdown.Err(); err != nil {
+		c.stopWriters(writers)
+		return err
+	}
+	for _, w := range writers {
+		c.workers[w.id] = w
+	}
+	c.updateWriteBehind()
+	return nil
+}
+
+// Stops writers that were started but never published, they have not been sent any batches
+func (c *Coordinator) stopWriters(writers []*MultiWriter) {
+	for _, w := range writers {
+		if err := w.Stop(c.workerCtx); err != nil {
+			c.log.Error("Failed to stop unpublished writer", slog.Int("partition", w.id), slog.Any("error", err))
+		}
+	}
+}
+
+// Returns the current client, it is replaced if it fails
+func (c *Coordinator) Client() *kgo.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+/*
+** Closes the current client, called once Run has returned and the coordinator has stopped. Closing the client does
+** not stop Run, which treats a closed client as a failure and reconnects, cancel its context instead. A group
+** client revokes its partitions as it closes, so mu is not held.
+ */
+func (c *Coordinator) Close() {
+	c.Client().Close()
+}
+
+func (c *Coordinator) warnLoadTestMode() {
+	if c.stores[0].Transactions().LoadTestMode() {
+		c.log.Warn("Load test mode enabled, transaction IDs will be rewritten before they are persisted")
+		loadTestMode.Set(1)
+	}
+}
+
+/*
+** Polls and routes records until ctx is done. A static range's writers are started here, a group's are started by
+** assigned as partitions arrive, which may happen before Run.
+ */
+func (c *Coordinator) Run(ctx context.Context) error {
+	if c.group == "" {
+		if err := c.startWriters(ctx); err != nil {
+			// Stopped before the partitions were acquired
+			return nil
+		}
+	}
+
+	for _, wb := range c.writeBehinds {
+		wb.Start(c.workerCtx)
+	}
+	kafkaClientState.Set(clientConnected)
+
 	for {
-		fetches := c.client.PollFetches(ctx)
-		if fetches.IsClientClosed() {
-			c.log.WarnContext(ctx, "Kafka client closed", slog.Any("ctx_err", ctx.Err()), slog.Any("client_err", c.client.Context().Err()))
-			// TODO: retry reconnect
+		pollCtx, cancel := c.pollContext(ctx)
+		fetches := c.client.PollFetches(pollCtx)
+		cancel()
+		if ctx.Err() != nil {
 			return nil
 		}
 
-		iter := fetches.RecordIter()
+		c.mu.Lock()
+		c.route(fetches)
+		c.mu.Unlock()
+
+		// Every record polled is with its writer or in a slab, a revoke flushes and drains them
+		c.client.AllowRebalance()
 
-		for !iter.Done() {
-			rec := iter.Next()
-			workerID := int(rec.Partition) % numWorkers
-			batch := activeSlabs[workerID]
+		if err := c.fetchError(ctx, fetches); err != nil {
+			c.log.ErrorContext(ctx, "Kafka client failed, reconnecting", slog.Any("error", err), slog.Duration("backoff", c.backoff))
+			if err := c.reconnect(ctx); err != nil {
+				return nil
+			}
+		} else if fetches.NumRecords() > 0 {
+			// The client works again, a later failure reconnects straight away
+			c.backoff = 0
+		}
+	}
+}
 
-			dest := &batch.Slab[batch.Count]
-			*dest = *rec
-			copy(batch.ByteSlab[batch.offset:], rec.Value)
-			dest.Value = batch.ByteSlab[batch.offset : batch.offset+len(rec.Value)]
-			batch.offset += len(rec.Value)
-			batch.Count++
+/*
+** Classifies the errors of a poll and returns the first one the client cannot recover from by itself. The client
+** retries retriable errors internally and reports them only so they can be seen, and data loss is reported after
+** consumption has already moved on, so those are logged and counted. A closed client, or a non-retriable error
+** such as a failed authorization or a batch that does not parse, needs a new client.
+ */
+func (c *Coordinator) fetchError(ctx context.Context, fetches kgo.Fetches) error {
+	if fetches.IsClientClosed() {
+		return kgo.ErrClientClosed
+	}
 
-			if batch.Count >= 50000 {
-				c.dispatch(workerID, batch)
-				newSlab := recordsPool.Get().(*RecordBatch)
-				activeSlabs[workerID] = newSlab
+	var fatal error
+	fetches.EachError(func(topic string, partition int32, err error) {
+		var dataLoss *kgo.ErrDataLoss
+		var groupErr *kgo.ErrGroupSession
+		switch {
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			return
+		case errors.As(err, &dataLoss):
+			kafkaFetchErrors.WithLabelValues("data_loss").Inc()
+			c.log.WarnContext(ctx, "Kafka reported data loss", slog.Int("partition", int(partition)), slog.Any("error", err))
+		case errors.As(err, &groupErr) || kerr.IsRetriable(err):
+			kafkaFetchErrors.WithLabelValues("retriable").Inc()
+			c.log.WarnContext(ctx, "Retriable fetch error", slog.Int("partition", int(partition)), slog.Any("error", err))
+		default:
+			kafkaFetchErrors.WithLabelValues("fatal").Inc()
+			if fatal == nil {
+				fatal = fmt.Errorf("failed to fetch %s/%d: %v", topic, partition, err)
 			}
 		}
+	})
+	return fatal
+}
+
+/*
+** Replaces a failed client. Every writer is drained first, so the offsets in kafka_offsets cover each record that
+** was polled and the new client fetches nothing twice. A group client revokes its partitions as it closes and
+** rejoins when polled, its writers are started again as partitions are assigned. A static range gets new writers,
+** which acquire their partitions again. Attempts back off from where the previous reconnect left off until a poll
+** returns records. Returns an error only once the coordinator's context is done or it has been stopped.
+ */
+func (c *Coordinator) reconnect(ctx context.Context) error {
+	kafkaClientState.Set(clientReconnecting)
+
+	c.client.Close()
+
+	c.mu.Lock()
+	c.flushAll(flushDrain)
+	stopCtx, cancel := context.WithTimeout(ctx, revokeTimeout)
+	for p, w := range c.workers {
+		if w == nil {
+			continue
+		}
+		c.workers[p] = nil
+		if err := w.Stop(stopCtx); err != nil {
+			c.log.ErrorContext(ctx, "Failed to drain partition before reconnecting", slog.Int("partition", p), slog.Any("error", err))
+		}
+	}
+	cancel()
+	clear(c.startOffsets)
+	c.updateWriteBehind()
+	c.mu.Unlock()
+
+	for {
+		// A client that connects but fails its next fetch again still backs off, so a persistent fetch error does
+		// not drain and restart every writer in a tight loop
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.backoff):
+		}
+		c.backoff = min(max(c.backoff*2, reconnectMinBackoff), reconnectMaxBackoff)
 
-		for i, batch := range activeSlabs {
-			if batch.Count > 0 {
-				c.dispatch(i, batch)
-				activeSlabs[i] = recordsPool.Get().(*RecordBatch)
-				activeSlabs[i].Reset()
+		kafkaReconnects.Inc()
+		client, err := c.connect(ctx)
+		if err == nil {
+			c.mu.Lock()
+			c.client = client
+			c.mu.Unlock()
+			if c.group == "" {
+				if err := c.startWriters(ctx); err != nil {
+					return err
+				}
 			}
+
+			kafkaClientState.Set(clientConnected)
+			c.log.InfoContext(ctx, "Kafka client reconnected")
+			return nil
+		}
+		c.log.ErrorContext(ctx, "Failed to reconnect Kafka client", slog.Any("error", err), slog.Duration("backoff", c.backoff))
+	}
+}
+
+// Creates a client and checks the brokers can be reached before it replaces the failed one
+func (c *Coordinator) connect(ctx context.Context) (*kgo.Client, error) {
+	client, err := c.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := client.Ping(pingCtx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping broker client: %v", err)
+	}
+	return client, nil
+}
+
+/*
+** Copies records into per-partition slabs and dispatches each one when it reaches a bound of the batch config,
+** called with mu held. A slab is flushed before a record would overflow its bytes, and a record larger than a
+** whole slab is sent in a batch of its own.
+ */
+func (c *Coordinator) route(fetches kgo.Fetches) {
+	iter := fetches.RecordIter()
+
+	for !iter.Done() {
+		rec := iter.Next()
+		partition := int(rec.Partition)
+		if c.workers[partition] == nil {
+			// Only possible for a partition lost mid-poll, its next owner starts from the committed offset
+			continue
+		}
+
+		batch := c.activeSlabs[partition]
+		if batch != nil && batch.offset+len(rec.Value) > len(batch.ByteSlab) {
+			c.flush(partition, flushBytes)
+			batch = nil
+		}
+		if batch == nil {
+			batch = c.slabs.Get().(*RecordBatch)
+			batch.Reset()
+			batch.started = time.Now()
+			c.activeSlabs[partition] = batch
+		}
+
+		dest := &batch.Slab[batch.Count]
+		*dest = *rec
+		batch.Count++
+		if len(rec.Value) > len(batch.ByteSlab) {
+			// The value stays in the fetch's buffer rather than the slab
+			c.flush(partition, flushBytes)
+			continue
+		}
+		copy(batch.ByteSlab[batch.offset:], rec.Value)
+		dest.Value = batch.ByteSlab[batch.offset : batch.offset+len(rec.Value)]
+		batch.offset += len(rec.Value)
+
+		if batch.Count >= c.batch.MaxRecords {
+			c.flush(partition, flushRecords)
+		}
+	}
+
+	if c.batch.Linger == 0 {
+		c.flushAll(flushPoll)
+		return
+	}
+	now := time.Now()
+	for p, batch := range c.activeSlabs {
+		if batch != nil && now.Sub(batch.started) >= c.batch.Linger {
+			c.flush(p, flushLinger)
+		}
+	}
+}
+
+// Bounds a poll by the linger of the oldest pending batch, so it is flushed on time even if no records arrive
+func (c *Coordinator) pollContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var oldest time.Time
+	for _, batch := range c.activeSlabs {
+		if batch != nil && (oldest.IsZero() || batch.started.Before(oldest)) {
+			oldest = batch.started
 		}
 	}
+	if oldest.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, oldest.Add(c.batch.Linger))
+}
+
+// Dispatches a partition's pending batch, if it has one, called with mu held
+func (c *Coordinator) flush(partition int, reason string) {
+	batch := c.activeSlabs[partition]
+	if batch == nil {
+		return
+	}
+	c.activeSlabs[partition] = nil
+	if batch.Count == 0 {
+		c.slabs.Put(batch)
+		return
+	}
+	batchesFlushed.WithLabelValues(reason).Inc()
+	c.dispatch(partition, batch)
+}
+
+func (c *Coordinator) flushAll(reason string) {
+	for p := range c.activeSlabs {
+		c.flush(p, reason)
+	}
 }
 
 func (c *Coordinator) Stop(ctx context.Context) error {
-	for _, w := range c.workers {
+	// Before taking mu, so writers still acquiring their partition give up rather than being published
+	c.stopping()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushAll(flushDrain)
+
+	for i, w := range c.workers {
+		if w == nil {
+			continue
+		}
+		c.workers[i] = nil
 		if err := w.Stop(ctx); err != nil {
 			return err
 		}
 	}
-	if err := c.writeBehind.Stop(ctx); err != nil {
-		return err
+	for _, wb := range c.writeBehinds {
+		if err := wb.Stop(ctx); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -115,3 +573,131 @@ func (c *Coordinator) dispatch(workerID int, batch *RecordBatch) {
 
 	c.workers[workerID].WorkChan <- batch
 }
+
+func (c *Coordinator) storeFor(partition int32) (*storage.PostgresStore, int) {
+	shard := storage.ShardForPartition(partition, len(c.stores))
+	return c.stores[shard], shard
+}
+
+/*
+** Starts a writer for each newly assigned partition. Starting a writer acquires its partition, which waits out any
+** batch the previous owner still has in flight, so the offsets read afterwards include everything it committed.
+** This runs before the group fetches its offsets, so those loaded here are the ones adjustOffsets starts fetching
+** from. Loading is retried for as long as the client is open, a partition cannot be consumed without knowing what
+** its database already holds.
+ */
+func (c *Coordinator) assigned(ctx context.Context, client *kgo.Client, assigned map[string][]int32) {
+	partitions := assigned["transactions"]
+	if len(partitions) == 0 {
+		return
+	}
+
+	// Acquiring waits out the database, mu is not held so Stop is not blocked meanwhile. A partition that could not
+	// be acquired before the client closed or the coordinator stopped is left without a writer, its records are
+	// skipped and the next owner consumes them.
+	acquireCtx, cancel := c.acquireContext(ctx)
+	defer cancel()
+
+	var writers []*MultiWriter
+	for _, p := range partitions {
+		c.mu.Lock()
+		running := c.workers[p] != nil
+		c.mu.Unlock()
+		if running {
+			continue
+		}
+		store, _ := c.storeFor(p)
+		w := NewMultiWriter(int(p), c.log, store, client, c.slabs)
+		if err := w.Start(acquireCtx); err != nil {
+			c.log.ErrorContext(ctx, "Dropping assigned partition, it was not acquired", slog.Int("partition", int(p)), slog.Any("error", err))
+			continue
+		}
+		writers = append(writers, w)
+	}
+	if err := c.publishWriters(writers); err != nil {
+		return
+	}
+
+	var offsets map[int32]kgo.Offset
+	for {
+		var err error
+		offsets, err = c.loadOffsets(ctx, partitions)
+		if err == nil {
+			break
+		}
+		c.log.ErrorContext(ctx, "Failed to load offsets for assigned partitions", slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	c.mu.Lock()
+	maps.Copy(c.startOffsets, offsets)
+	c.mu.Unlock()
+
+	c.log.InfoContext(ctx, "Partitions assigned", slog.Any("partitions", partitions))
+}
+
+// Replaces the group's committed offsets, which this worker never writes, with those loaded from kafka_offsets
+func (c *Coordinator) adjustOffsets(_ context.Context, offsets map[string]map[int32]kgo.Offset) (map[string]map[int32]kgo.Offset, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for p := range offsets["transactions"] {
+		if o, ok := c.startOffsets[p]; ok {
+			offsets["transactions"][p] = o
+			delete(c.startOffsets, p)
+		}
+	}
+	return offsets, nil
+}
+
+/*
+** Drains the writers of revoked or lost partitions. Rebalances are blocked while a poll is routed, so every record
+** polled for them is in a writer's channel or a lingering batch, which is flushed here, and stopping the writer
+** waits for those batches and their offsets to commit.
+ */
+func (c *Coordinator) revoked(ctx context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	partitions := revoked["transactions"]
+	if len(partitions) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stopCtx, cancel := context.WithTimeout(ctx, revokeTimeout)
+	defer cancel()
+	for _, p := range partitions {
+		w := c.workers[p]
+		if w == nil {
+			continue
+		}
+		c.flush(int(p), flushDrain)
+		c.workers[p] = nil
+		delete(c.startOffsets, p)
+		if err := w.Stop(stopCtx); err != nil {
+			c.log.ErrorContext(ctx, "Failed to drain revoked partition", slog.Int("partition", int(p)), slog.Any("error", err))
+		}
+	}
+	c.updateWriteBehind()
+
+	c.log.InfoContext(ctx, "Partitions revoked", slog.Any("partitions", partitions))
+}
+
+// Points each shard's write-behind at the partitions currently owned on it, called with mu held
+func (c *Coordinator) updateWriteBehind() {
+	owned := make([][]int, len(c.writeBehinds))
+	for p, w := range c.workers {
+		if w != nil {
+			_, shard := c.storeFor(int32(p))
+			owned[shard] = append(owned[shard], p)
+		}
+	}
+	for shard, wb := range c.writeBehinds {
+		slices.Sort(owned[shard])
+		wb.SetPartitions(owned[shard])
+	}
+}
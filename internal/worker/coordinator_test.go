@@ -0,0 +1,275 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// A coordinator with unstarted writers for the first partitions, whose channels the test reads batches from
+func newTestCoordinator(cfg BatchConfig, partitions int) *Coordinator {
+	c := &Coordinator{
+		log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		batch:       cfg,
+		slabs:       newRecordsPool(cfg),
+		workers:     make([]*MultiWriter, partitions+1),
+		activeSlabs: make([]*RecordBatch, partitions+1),
+	}
+	for p := range partitions {
+		c.workers[p] = &MultiWriter{id: p, WorkChan: make(chan *RecordBatch, 64)}
+	}
+	return c
+}
+
+// Builds a poll holding one record per value on the partition, at consecutive offsets
+func testFetches(partition int32, values ...[]byte) kgo.Fetches {
+	records := make([]*kgo.Record, len(values))
+	for i, v := range values {
+		records[i] = &kgo.Record{Topic: "transactions", Partition: partition, Offset: int64(i), Value: v}
+	}
+	return kgo.Fetches{{Topics: []kgo.FetchTopic{{
+		Topic:      "transactions",
+		Partitions: []kgo.FetchPartition{{Partition: partition, Records: records}},
+	}}}}
+}
+
+func values(n int, size int) [][]byte {
+	vs := make([][]byte, n)
+	for i := range vs {
+		vs[i] = make([]byte, size)
+		vs[i][0] = byte(i)
+	}
+	return vs
+}
+
+// Takes every batch dispatched to the partition's writer so far
+func dispatched(c *Coordinator, partition int) []*RecordBatch {
+	var batches []*RecordBatch
+	for {
+		select {
+		case b := <-c.workers[partition].WorkChan:
+			batches = append(batches, b)
+		default:
+			return batches
+		}
+	}
+}
+
+// Snapshot of worker_batches_flushed_total, the tests compare the change across a route
+type flushCounts map[string]float64
+
+func readFlushCounts() flushCounts {
+	counts := make(flushCounts)
+	for _, reason := range []string{flushRecords, flushBytes, flushLinger, flushPoll, flushDrain} {
+		counts[reason] = testutil.ToFloat64(batchesFlushed.WithLabelValues(reason))
+	}
+	return counts
+}
+
+func assertFlushed(t *testing.T, before flushCounts, want map[string]float64) {
+	t.Helper()
+	after := readFlushCounts()
+	for reason := range after {
+		if got := after[reason] - before[reason]; got != want[reason] {
+			t.Errorf("batches flushed for %q = %v, want %v", reason, got, want[reason])
+		}
+	}
+}
+
+func assertCounts(t *testing.T, batches []*RecordBatch, want ...int) {
+	t.Helper()
+	if len(batches) != len(want) {
+		t.Fatalf("dispatched %d batches, want %d", len(batches), len(want))
+	}
+	for i, b := range batches {
+		if b.Count != want[i] {
+			t.Errorf("batch %d holds %d records, want %d", i, b.Count, want[i])
+		}
+	}
+}
+
+func TestRouteFlushesAtMaxRecords(t *testing.T) {
+	c := newTestCoordinator(BatchConfig{MaxRecords: 3, MaxBytes: 1000}, 1)
+	before := readFlushCounts()
+
+	c.route(testFetches(0, values(7, 10)...))
+
+	batches := dispatched(c, 0)
+	assertCounts(t, batches, 3, 3, 1)
+	assertFlushed(t, before, map[string]float64{flushRecords: 2, flushPoll: 1})
+
+	// Records keep their order and offsets across the batches
+	offset := int64(0)
+	for _, b := range batches {
+		for i := range b.Count {
+			if b.Slab[i].Offset != offset {
+				t.Errorf("record at offset %d routed as %d", offset, b.Slab[i].Offset)
+			}
+			offset++
+		}
+	}
+}
+
+func TestRouteFlushesBeforeOverflowingBytes(t *testing.T) {
+	c := newTestCoordinator(BatchConfig{MaxRecords: 100, MaxBytes: 25}, 1)
+	before := readFlushCounts()
+
+	c.route(testFetches(0, values(5, 10)...))
+
+	batches := dispatched(c, 0)
+	assertCounts(t, batches, 2, 2, 1)
+	assertFlushed(t, before, map[string]float64{flushBytes: 2, flushPoll: 1})
+
+	for i, b := range batches {
+		if b.offset > len(b.ByteSlab) {
+			t.Errorf("batch %d used %d bytes of a %d byte slab", i, b.offset, len(b.ByteSlab))
+		}
+		// Values are copied into the slab rather than left in the fetch
+		if &b.Slab[0].Value[0] != &b.ByteSlab[0] {
+			t.Errorf("batch %d first value is not in its slab", i)
+		}
+	}
+}
+
+func TestRouteSendsOversizedRecordAlone(t *testing.T) {
+	c := newTestCoordinator(BatchConfig{MaxRecords: 100, MaxBytes: 25}, 1)
+	before := readFlushCounts()
+
+	oversized := make([]byte, 40)
+	c.route(testFetches(0, make([]byte, 10), oversized, make([]byte, 10)))
+
+	batches := dispatched(c, 0)
+	assertCounts(t, batches, 1, 1, 1)
+	assertFlushed(t, before, map[string]float64{flushBytes: 2, flushPoll: 1})
+
+	// The oversized record keeps its value in the fetch's buffer
+	alone := batches[1]
+	if len(alone.Slab[0].Value) != len(oversized) || &alone.Slab[0].Value[0] != &oversized[0] {
+		t.Errorf("oversized record value was not left in the fetch buffer")
+	}
+	if alone.offset != 0 {
+		t.Errorf("oversized record used %d bytes of the slab, want 0", alone.offset)
+	}
+}
+
+func TestRouteSkipsPartitionsWithoutWriter(t *testing.T) {
+	c := newTestCoordinator(BatchConfig{MaxRecords: 100, MaxBytes: 1000}, 1)
+
+	c.route(testFetches(1, values(3, 10)...))
+
+	if c.activeSlabs[1] != nil {
+		t.Errorf("partition without a writer has a pending batch")
+	}
+}
+
+func TestRouteLingers(t *testing.T) {
+	const linger = time.Hour
+	c := newTestCoordinator(BatchConfig{MaxRecords: 100, MaxBytes: 1000, Linger: linger}, 2)
+	before := readFlushCounts()
+
+	// Within the linger the batch is kept for later polls
+	c.route(testFetches(0, values(2, 10)...))
+	c.route(testFetches(0, values(1, 10)...))
+	if batches := dispatched(c, 0); len(batches) != 0 {
+		t.Fatalf("dispatched %d batches within the linger, want 0", len(batches))
+	}
+	pending := c.activeSlabs[0]
+	if pending == nil || pending.Count != 3 {
+		t.Fatalf("pending batch = %+v, want 3 records", pending)
+	}
+
+	// A poll waits no longer than the oldest pending batch may linger
+	c.route(testFetches(1, values(1, 10)...))
+	c.activeSlabs[1].started = pending.started.Add(time.Minute)
+	pollCtx, cancel := c.pollContext(context.Background())
+	deadline, ok := pollCtx.Deadline()
+	cancel()
+	if !ok || !deadline.Equal(pending.started.Add(linger)) {
+		t.Errorf("poll deadline = %v (set %v), want %v", deadline, ok, pending.started.Add(linger))
+	}
+
+	// Once it has lingered long enough the next poll flushes it, even an empty one
+	pending.started = time.Now().Add(-linger)
+	c.route(kgo.Fetches{})
+	assertCounts(t, dispatched(c, 0), 3)
+	assertCounts(t, dispatched(c, 1))
+	assertFlushed(t, before, map[string]float64{flushLinger: 1})
+
+	// Without pending batches a poll is not bounded
+	c.flushAll(flushDrain)
+	pollCtx, cancel = c.pollContext(context.Background())
+	defer cancel()
+	if _, ok := pollCtx.Deadline(); ok {
+		t.Errorf("poll without pending batches has a deadline")
+	}
+}
+
+// Builds a poll whose partitions each failed with one of errs
+func errorFetches(errs ...error) kgo.Fetches {
+	partitions := make([]kgo.FetchPartition, len(errs))
+	for i, err := range errs {
+		partitions[i] = kgo.FetchPartition{Partition: int32(i), Err: err}
+	}
+	return kgo.Fetches{{Topics: []kgo.FetchTopic{{Topic: "transactions", Partitions: partitions}}}}
+}
+
+func TestFetchError(t *testing.T) {
+	tests := []struct {
+		name      string
+		fetches   kgo.Fetches
+		wantFatal bool
+		wantKinds map[string]float64
+	}{
+		{name: "no errors", fetches: testFetches(0, values(2, 10)...)},
+		{name: "cancelled poll", fetches: errorFetches(context.Canceled, context.DeadlineExceeded)},
+		{name: "retriable", fetches: errorFetches(kerr.NotLeaderForPartition), wantKinds: map[string]float64{"retriable": 1}},
+		{name: "group session", fetches: errorFetches(&kgo.ErrGroupSession{}), wantKinds: map[string]float64{"retriable": 1}},
+		{name: "data loss", fetches: errorFetches(&kgo.ErrDataLoss{Topic: "transactions"}), wantKinds: map[string]float64{"data_loss": 1}},
+		{name: "authorization", fetches: errorFetches(kerr.TopicAuthorizationFailed), wantFatal: true, wantKinds: map[string]float64{"fatal": 1}},
+		{
+			name:      "fatal among retriable",
+			fetches:   errorFetches(kerr.NotLeaderForPartition, kerr.ClusterAuthorizationFailed, kerr.TopicAuthorizationFailed),
+			wantFatal: true,
+			wantKinds: map[string]float64{"retriable": 1, "fatal": 2},
+		},
+		{name: "client closed", fetches: errorFetches(kgo.ErrClientClosed), wantFatal: true},
+	}
+
+	c := newTestCoordinator(BatchConfig{MaxRecords: 100, MaxBytes: 1000}, 1)
+	kinds := []string{"retriable", "data_loss", "fatal"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make(map[string]float64)
+			for _, kind := range kinds {
+				before[kind] = testutil.ToFloat64(kafkaFetchErrors.WithLabelValues(kind))
+			}
+
+			err := c.fetchError(context.Background(), tt.fetches)
+			if (err != nil) != tt.wantFatal {
+				t.Errorf("fetchError() = %v, want fatal %v", err, tt.wantFatal)
+			}
+			for _, kind := range kinds {
+				if got := testutil.ToFloat64(kafkaFetchErrors.WithLabelValues(kind)) - before[kind]; got != tt.wantKinds[kind] {
+					t.Errorf("%s fetch errors counted = %v, want %v", kind, got, tt.wantKinds[kind])
+				}
+			}
+		})
+	}
+
+	// The first fatal error is the one returned
+	err := c.fetchError(context.Background(), errorFetches(kerr.NotLeaderForPartition, kerr.ClusterAuthorizationFailed, kerr.TopicAuthorizationFailed))
+	if err == nil || !strings.Contains(err.Error(), "transactions/1") {
+		t.Errorf("fetchError() = %v, want the error of transactions/1", err)
+	}
+	if err := c.fetchError(context.Background(), errorFetches(kgo.ErrClientClosed)); !errors.Is(err, kgo.ErrClientClosed) {
+		t.Errorf("fetchError() of a closed client = %v, want %v", err, kgo.ErrClientClosed)
+	}
+}
@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+/*
+** Checks a decoded record before it is staged. The API validates what it produces, but records from other
+** producers are not, and a row the binary COPY cannot encode, such as metadata that is not JSON, would fail the
+** whole batch on every redelivery and stall the partition.
+ */
+func checkRecord(tx *pb.Transaction) error {
+	switch {
+	case len(tx.Id) != 16 || uuid.UUID(tx.Id) == uuid.Nil:
+		return errors.New("invalid id")
+	case len(tx.AccountId) != 16 || uuid.UUID(tx.AccountId) == uuid.Nil:
+		return errors.New("invalid account_id")
+	case tx.Amount == 0:
+		return errors.New("zero amount")
+	case !utf8.ValidString(tx.Currency):
+		return errors.New("currency is not valid UTF-8")
+	case !utf8.ValidString(tx.ExternalRef):
+		return errors.New("external_ref is not valid UTF-8")
+	case len(tx.Metadata) > 0 && !json.Valid(tx.Metadata):
+		return errors.New("metadata is not valid JSON")
+	}
+	return nil
+}
+
+// Builds the dead letter for a record, keeping its key, value and headers so it can be re-driven unchanged
+func newDeadLetter(rec *kgo.Record, err error) *kgo.Record {
+	headers := make([]kgo.RecordHeader, 0, len(rec.Headers)+3)
+	headers = append(headers, rec.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: model.DeadLetterErrorHeader, Value: []byte(err.Error())},
+		kgo.RecordHeader{Key: model.DeadLetterPartitionHeader, Value: []byte(strconv.Itoa(int(rec.Partition)))},
+		kgo.RecordHeader{Key: model.DeadLetterOffsetHeader, Value: []byte(strconv.FormatInt(rec.Offset, 10))},
+	)
+
+	return &kgo.Record{
+		Topic:   model.DeadLetterTopic,
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: headers,
+	}
+}
+
+/*
+** Produces dead letters before the batch they were taken from is written, since writing it commits an offset past
+** them. Retries the records the broker has not taken until it takes them, the same way batch writes are retried, so
+** a record is never skipped without a copy in the DLQ. A crash in between can dead letter a record twice. Once the
+** client is closed, by a revoke or a reconnect, retrying cannot succeed: the error is returned and the batch must
+** not be written, so its offset stays uncommitted and the partition's next owner reads the records again.
+ */
+func (w *MultiWriter) deadLetter(ctx context.Context, records []*kgo.Record) error {
+	pending := records
+	for {
+		// Only the records that failed are produced again, the others are already in the DLQ
+		var failed []*kgo.Record
+		var err error
+		for _, r := range w.client.ProduceSync(ctx, pending...) {
+			if r.Err == nil {
+				continue
+			}
+			failed = append(failed, r.Record)
+			if err == nil || errors.Is(r.Err, kgo.ErrClientClosed) {
+				err = r.Err
+			}
+		}
+		transactionsDeadLettered.Add(float64(len(pending) - len(failed)))
+		if len(failed) == 0 {
+			return nil
+		}
+		if errors.Is(err, kgo.ErrClientClosed) {
+			return err
+		}
+		w.log.ErrorContext(ctx, "Failed to produce dead letters", slog.Int("count", len(failed)), slog.Any("error", err), slog.Int("worker_id", w.id))
+		time.Sleep(5 * time.Second)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pending = failed
+	}
+}
@@ -0,0 +1,231 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/alexmcook/transaction-ledger/internal/model"
+	"github.com/alexmcook/transaction-ledger/internal/storage"
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const journalRetryInterval = time.Second
+
+/*
+** Applies double-entry transfers from the journal topic. An entry's legs are merged into the partition tables of the
+** shards owning their accounts, and the entry itself is recorded on its home shard (the shard its ID maps to). When
+** more than one shard is involved every part is prepared first, then the home part is committed, which decides the
+** entry, and the remaining parts after it. Only one processor may run against a set of shards, since in-doubt
+** prepared parts are resolved on the assumption that no other entry is in flight.
+ */
+type JournalProcessor struct {
+	log    *slog.Logger
+	client *kgo.Client
+	shards []*storage.JournalStore
+}
+
+func NewJournalProcessor(log *slog.Logger, client *kgo.Client, pools []*pgxpool.Pool) *JournalProcessor {
+	shards := make([]*storage.JournalStore, len(pools))
+	for i, pool := range pools {
+		shards[i] = storage.NewJournalStore(pool)
+	}
+	return &JournalProcessor{
+		log:    log,
+		client: client,
+		shards: shards,
+	}
+}
+
+func (p *JournalProcessor) shardFor(id uuid.UUID) int {
+	return storage.ShardForPartition(storage.PartitionForAccount(id), len(p.shards))
+}
+
+func (p *JournalProcessor) Run(ctx context.Context) error {
+	if err := p.resolveInDoubt(ctx); err != nil {
+		return err
+	}
+
+	for {
+		fetches := p.client.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return nil
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			p.log.ErrorContext(ctx, "Failed to fetch journal entries", slog.String("topic", topic), slog.Int("partition", int(partition)), slog.Any("error", err))
+		})
+
+		iter := fetches.RecordIter()
+		for !iter.Done() {
+			rec := iter.Next()
+			// Entries are retried until they are applied or rejected, skipping one would lose the transfer
+			for {
+				err := p.process(ctx, rec)
+				if err == nil {
+					break
+				}
+				p.log.ErrorContext(ctx, "Failed to process journal entry", slog.Int64("offset", rec.Offset), slog.Any("error", err))
+				journalRetries.Inc()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(journalRetryInterval):
+				}
+				if err := p.resolveInDoubt(ctx); err != nil {
+					p.log.ErrorContext(ctx, "Failed to resolve in-doubt journal entries", slog.Any("error", err))
+				}
+			}
+		}
+
+		if err := p.client.CommitUncommittedOffsets(ctx); err != nil {
+			p.log.ErrorContext(ctx, "Failed to commit journal offsets", slog.Any("error", err))
+		}
+	}
+}
+
+func (p *JournalProcessor) process(ctx context.Context, rec *kgo.Record) error {
+	start := time.Now()
+
+	var msg pb.JournalEntry
+	if err := msg.UnmarshalVT(rec.Value); err != nil || len(msg.Id) != 16 {
+		p.log.ErrorContext(ctx, "Dropping undecodable journal entry", slog.Int64("offset", rec.Offset), slog.Any("error", err))
+		return nil
+	}
+
+	legs := make([]model.JournalLeg, len(msg.Legs))
+	for i, leg := range msg.Legs {
+		if len(leg.AccountId) == 16 {
+			legs[i].AccountID = uuid.UUID(leg.AccountId)
+		}
+		legs[i].Amount = leg.Amount
+	}
+	entry := storage.NewJournalEntry(uuid.UUID(msg.Id), msg.Currency, legs)
+	home := p.shardFor(entry.ID)
+
+	existing, err := p.shards[home].GetJournalEntry(ctx, entry.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		p.log.DebugContext(ctx, "Skipping redelivered journal entry", slog.String("id", entry.ID.String()))
+		return nil
+	}
+
+	if err := storage.ValidateJournalEntry(entry); err != nil {
+		return p.reject(ctx, home, entry, model.RejectReasonInvalidEntry)
+	}
+
+	// The home part is always first, its commit decides the entry
+	legsByShard := make(map[int][]model.JournalLeg)
+	legsByShard[home] = nil
+	for _, leg := range entry.Legs {
+		shard := p.shardFor(leg.AccountID)
+		legsByShard[shard] = append(legsByShard[shard], leg)
+	}
+	order := []int{home}
+	for shard := range legsByShard {
+		if shard != home {
+			order = append(order, shard)
+		}
+	}
+	slices.Sort(order[1:])
+
+	var parts []*storage.JournalPart
+	abort := func() {
+		for _, part := range parts {
+			if err := part.Rollback(ctx); err != nil {
+				p.log.ErrorContext(ctx, "Failed to roll back journal part", slog.String("id", entry.ID.String()), slog.Any("error", err))
+			}
+		}
+	}
+
+	for _, shard := range order {
+		part, reason, err := p.shards[shard].BeginJournalPart(ctx, entry, legsByShard[shard], shard == home, storage.JournalGID(entry.ID, shard))
+		if err != nil {
+			abort()
+			if errors.Is(err, storage.ErrJournalLegExists) {
+				p.log.ErrorContext(ctx, "Dropping journal entry whose legs are already recorded", slog.String("id", entry.ID.String()))
+				return nil
+			}
+			return err
+		}
+		if reason != "" {
+			abort()
+			return p.reject(ctx, home, entry, reason)
+		}
+		parts = append(parts, part)
+	}
+
+	if len(parts) > 1 {
+		for _, part := range parts {
+			if err := part.Prepare(ctx); err != nil {
+				abort()
+				return err
+			}
+		}
+	}
+
+	// Once the home part is committed the entry is applied, a failure committing the rest is completed by
+	// resolveInDoubt before the entry is retried
+	for i, part := range parts {
+		if err := part.Commit(ctx); err != nil {
+			for _, rest := range parts[i+1:] {
+				rest.Release()
+			}
+			return err
+		}
+	}
+
+	journalEntriesApplied.Inc()
+	journalEntryLatency.Observe(time.Since(start).Seconds())
+	return nil
+}
+
+func (p *JournalProcessor) reject(ctx context.Context, home int, entry *model.JournalEntry, reason string) error {
+	if err := p.shards[home].RejectJournalEntry(ctx, entry, reason); err != nil {
+		return err
+	}
+	p.log.InfoContext(ctx, "Rejected journal entry", slog.String("id", entry.ID.String()), slog.String("reason", reason))
+	journalEntriesRejected.WithLabelValues(reason).Inc()
+	return nil
+}
+
+/*
+** Finishes parts left prepared by a processor that stopped mid-commit. The home part is committed first, so an entry
+** recorded on its home shard must be committed everywhere, and anything else was never decided and is rolled back
+** to be retried from Kafka.
+ */
+func (p *JournalProcessor) resolveInDoubt(ctx context.Context) error {
+	for _, shard := range p.shards {
+		gids, err := shard.PreparedJournalParts(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, gid := range gids {
+			id, ok := storage.ParseJournalGID(gid)
+			if !ok {
+				continue
+			}
+
+			entry, err := p.shards[p.shardFor(id)].GetJournalEntry(ctx, id)
+			if err != nil {
+				return err
+			}
+			commit := entry != nil && entry.Status == model.JournalStatusApplied
+
+			if err := shard.FinishPrepared(ctx, gid, commit); err != nil {
+				return err
+			}
+			p.log.WarnContext(ctx, "Resolved in-doubt journal part", slog.String("gid", gid), slog.Bool("committed", commit))
+			journalInDoubtResolved.Inc()
+		}
+	}
+	return nil
+}
@@ -2,19 +2,27 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alexmcook/transaction-ledger/internal/model"
 	"github.com/alexmcook/transaction-ledger/internal/storage"
+	pb "github.com/alexmcook/transaction-ledger/proto"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
 type MultiWriter struct {
 	id         int
 	log        *slog.Logger
 	db         *storage.PostgresStore
+	client     *kgo.Client // Produces dead letters
+	slabs      *sync.Pool  // The coordinator's slabs, each batch is returned once decoded
 	WorkChan   chan *RecordBatch
 	workerWg   sync.WaitGroup
 	bufA       *storage.EfficientTransactionSource
@@ -22,21 +30,46 @@ type MultiWriter struct {
 	currentBuf *storage.EfficientTransactionSource
 }
 
-func NewMultiWriter(id int, log *slog.Logger, db *storage.PostgresStore) *MultiWriter {
+func NewMultiWriter(id int, log *slog.Logger, db *storage.PostgresStore, client *kgo.Client, slabs *sync.Pool) *MultiWriter {
 	return &MultiWriter{
 		id:       id,
 		log:      log,
 		WorkChan: make(chan *RecordBatch, 4),
 		db:       db,
+		client:   client,
+		slabs:    slabs,
 	}
 }
 
-func (w *MultiWriter) Start(ctx context.Context) {
-	w.bufA = storage.NewEfficientTransactionSource()
-	w.bufB = storage.NewEfficientTransactionSource()
+/*
+** Acquires the partition before writing anything, fencing off any other worker still writing it. Retries until
+** the database answers, the partition cannot be written without an epoch. Returns ctx's error if it is done
+** first, the writer is not started then. Once started the writer only stops through Stop, ctx is not watched.
+ */
+func (w *MultiWriter) Start(ctx context.Context) error {
+	rewriteIDs := w.db.Transactions().LoadTestMode()
+	w.bufA = storage.NewEfficientTransactionSource(rewriteIDs)
+	w.bufB = storage.NewEfficientTransactionSource(rewriteIDs)
+
+	for {
+		epoch, err := w.db.Transactions().AcquirePartition(ctx, w.id)
+		if err == nil {
+			w.bufA.Epoch = epoch
+			w.bufB.Epoch = epoch
+			w.log.InfoContext(ctx, "Acquired partition", slog.Int64("epoch", epoch), slog.Int("worker_id", w.id))
+			break
+		}
+		w.log.ErrorContext(ctx, "Failed to acquire partition", slog.Any("error", err), slog.Int("worker_id", w.id))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
 
 	w.workerWg.Add(1)
-	go w.startWorker(ctx)
+	go w.startWorker(context.WithoutCancel(ctx))
+	return nil
 }
 
 func (w *MultiWriter) Stop(ctx context.Context) error {
@@ -64,23 +97,75 @@ func (w *MultiWriter) swap(currentBuf *storage.EfficientTransactionSource) *stor
 	return w.bufA
 }
 
+// Records produced before batch IDs existed, or by other producers, carry no header and are tracked as uuid.Nil
+func batchIDFromHeaders(headers []kgo.RecordHeader) uuid.UUID {
+	for _, h := range headers {
+		if h.Key == model.BatchIDHeader && len(h.Value) == 16 {
+			return uuid.UUID(h.Value)
+		}
+	}
+	return uuid.Nil
+}
+
 func (w *MultiWriter) startWorker(ctx context.Context) {
 	workerIDStr := strconv.Itoa(w.id)
+	rewriteIDs := w.db.Transactions().LoadTestMode()
 	currentBuf := w.bufA
 	defer w.workerWg.Done()
 	var writeWg sync.WaitGroup
+	var dead []*kgo.Record
+	var halted atomic.Bool // Fenced, or dead letters could not be produced, no later batch may commit its offset
 	for f := range w.WorkChan {
+		// Drain the channel without writing so the coordinator never blocks, the partition's next owner re-reads
+		// everything past the last committed offset
+		if halted.Load() {
+			f.Reset()
+			w.slabs.Put(f)
+			continue
+		}
 		currentBuf.Timestamp = time.Now()
 
 		batch := f.Slab
+		count := 0
+		dead = dead[:0]
 		for i := range f.Count {
-			currentBuf.Txs[i].UnmarshalVT(f.Slab[i].Value)
+			// UnmarshalVT only sets the fields present on the wire, clear the previous record's values first
+			tx := &currentBuf.Txs[count]
+			*tx = pb.Transaction{Id: tx.Id[:0], AccountId: tx.AccountId[:0], Metadata: tx.Metadata[:0]}
+			err := tx.UnmarshalVT(batch[i].Value)
+			if err != nil {
+				err = fmt.Errorf("failed to decode transaction: %v", err)
+			} else {
+				err = checkRecord(tx)
+			}
+			if err != nil {
+				w.log.WarnContext(ctx, "Dead lettering record", slog.Int64("offset", batch[i].Offset), slog.Any("error", err), slog.Int("worker_id", w.id))
+				dead = append(dead, newDeadLetter(&batch[i], err))
+				if id := batchIDFromHeaders(batch[i].Headers); id != uuid.Nil {
+					currentBuf.DeadLettered[id]++
+				}
+				continue
+			}
+			currentBuf.BatchIDs[count] = batchIDFromHeaders(batch[i].Headers)
+			count++
 		}
+		// The offset covers dead lettered records too, so the partition moves past them
 		currentBuf.Offset = batch[f.Count-1].Offset
-		currentBuf.Count = f.Count
+		currentBuf.Count = count
+
+		// Dead letters share the slab's bytes, produce them before it is returned to the pool
+		if len(dead) > 0 {
+			if err := w.deadLetter(ctx, dead); err != nil {
+				w.log.ErrorContext(ctx, "Dropping batch, its dead letters were not produced", slog.Any("error", err), slog.Int("worker_id", w.id))
+				halted.Store(true)
+				f.Reset()
+				w.slabs.Put(f)
+				continue
+			}
+		}
 
 		f.Reset()
-		recordsPool.Put(f)
+		w.slabs.Put(f)
 
 		w.log.DebugContext(ctx, "Staging batch", slog.Int("count", currentBuf.Count), slog.Int("worker_id", w.id))
 
@@ -91,6 +176,12 @@ func (w *MultiWriter) startWorker(ctx context.Context) {
 			for {
 				startBatch := time.Now()
 				if err := w.db.Transactions().EfficientWriteBatch(ctx, w.id, buf); err != nil {
+					if errors.Is(err, storage.ErrPartitionFenced) {
+						w.log.ErrorContext(ctx, "Partition fenced, stopping writes", slog.Any("error", err), slog.Int("worker_id", w.id))
+						partitionsFenced.Inc()
+						halted.Store(true)
+						break
+					}
 					w.log.ErrorContext(ctx, "Failed to write batch", slog.Int("count", buf.Count), slog.Any("error", err), slog.Int("worker_id", w.id))
 					time.Sleep(5 * time.Second)
 					select {
@@ -104,6 +195,11 @@ func (w *MultiWriter) startWorker(ctx context.Context) {
 				kafkaCommittedOffset.WithLabelValues(workerIDStr).Set(float64(buf.Offset))
 				dbWriteLatency.Observe(time.Since(startBatch).Seconds())
 				transactionsStaged.Add(float64(buf.Count))
+				transactionsMerged.Add(float64(buf.Merged))
+				transactionsRejected.Add(float64(buf.Rejected))
+				if rewriteIDs {
+					transactionIDsRewritten.Add(float64(buf.Count))
+				}
 				break
 			}
 			buf.Reset() // Use local variable to avoid race condition
@@ -6,22 +6,20 @@ import (
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-var recordsPool = sync.Pool{
-	New: func() any {
-		const batchSize = 50000
-		const avgProtoSize = 49
-		const safetyMargin = 15
-		const totalByteSlabSize = batchSize * (avgProtoSize + safetyMargin)
-
-		r := &RecordBatch{
-			Slab:     make([]kgo.Record, batchSize),   // Preallocate slab of records for cache locality
-			Pointers: make([]*kgo.Record, batchSize),  // Preallocate slice of pointers for Kafka client interface
-			ByteSlab: make([]byte, totalByteSlabSize), // Preallocate byte slab for protobuf payloads
-			offset:   0,
-		}
-		for i := range r.Slab {
-			r.Pointers[i] = &r.Slab[i] // Point to slab records
-		}
-		return r
-	},
+// Slabs sized to the coordinator's batch bounds, shared by its writers which return each slab once it is decoded
+func newRecordsPool(cfg BatchConfig) *sync.Pool {
+	return &sync.Pool{
+		New: func() any {
+			r := &RecordBatch{
+				Slab:     make([]kgo.Record, cfg.MaxRecords),  // Preallocate slab of records for cache locality
+				Pointers: make([]*kgo.Record, cfg.MaxRecords), // Preallocate slice of pointers for Kafka client interface
+				ByteSlab: make([]byte, cfg.MaxBytes),          // Preallocate byte slab for protobuf payloads
+				offset:   0,
+			}
+			for i := range r.Slab {
+				r.Pointers[i] = &r.Slab[i] // Point to slab records
+			}
+			return r
+		},
+	}
 }
@@ -11,6 +11,36 @@ var (
 		Help: "Total number of transactions staged by the worker",
 	})
 
+	transactionsMerged = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_transactions_merged_total",
+		Help: "Total number of transactions merged into the partition tables, excluding duplicates and rejections",
+	})
+
+	transactionsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_transactions_rejected_total",
+		Help: "Total number of transactions rejected into rejected_transactions",
+	})
+
+	transactionsDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_transactions_dead_lettered_total",
+		Help: "Total number of records sent to the dead-letter topic instead of being staged",
+	})
+
+	partitionsFenced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_partitions_fenced_total",
+		Help: "Total number of partition writers stopped because another worker acquired their partition",
+	})
+
+	transactionIDsRewritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_transaction_ids_rewritten_total",
+		Help: "Total number of transaction IDs rewritten by load test mode",
+	})
+
+	loadTestMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_load_test_mode",
+		Help: "Whether the worker is rewriting transaction IDs for load testing (1) or persisting them as sent (0)",
+	})
+
 	fetchLatency = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "worker_transaction_fetch_duration_seconds",
 		Help:    "Duration of transaction fetching by the worker",
@@ -23,6 +53,63 @@ var (
 		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1.0, 2.5, 5.0},
 	})
 
+	writeBehindLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "worker_write_behind_duration_seconds",
+		Help:    "Duration of applying and archiving a partition in the write behind worker",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1.0, 2.5, 5.0},
+	})
+
+	transactionsArchived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_transactions_archived_total",
+		Help: "Total number of transactions applied to balances and archived to transactions_history",
+	})
+
+	journalEntriesApplied = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_journal_entries_applied_total",
+		Help: "Total number of journal entries whose legs were all applied",
+	})
+
+	journalEntriesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_journal_entries_rejected_total",
+		Help: "Total number of journal entries rejected as a whole, by reason",
+	}, []string{"reason"})
+
+	journalRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_journal_retries_total",
+		Help: "Total number of journal entries retried after a processing error",
+	})
+
+	journalInDoubtResolved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_journal_in_doubt_resolved_total",
+		Help: "Total number of prepared journal parts committed or rolled back after an interrupted commit",
+	})
+
+	journalEntryLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "worker_journal_entry_duration_seconds",
+		Help:    "Duration of applying a journal entry across its shards",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1.0, 2.5, 5.0},
+	})
+
+	batchesFlushed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_batches_flushed_total",
+		Help: "Total number of batches handed to partition writers, by the bound that flushed them",
+	}, []string{"reason"})
+
+	kafkaClientState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_kafka_client_state",
+		Help: "State of the worker's Kafka client, connected (0) or reconnecting after a failure (1)",
+	})
+
+	kafkaReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_kafka_reconnects_total",
+		Help: "Total number of attempts to rebuild the Kafka client after it failed",
+	})
+
+	kafkaFetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_kafka_fetch_errors_total",
+		Help: "Total number of errors returned by Kafka fetches, by kind (retriable, data_loss, fatal)",
+	}, []string{"kind"})
+
 	kafkaHighWatermark = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "worker_kafka_high_watermark",
 		Help: "High watermark of the Kafka consumer for each partition",
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"errors"
+	"time"
 
 	"github.com/twmb/franz-go/pkg/kgo"
 )
@@ -12,11 +13,13 @@ type RecordBatch struct {
 	ByteSlab []byte
 	Count    int
 	offset   int
+	started  time.Time // When the coordinator copied the first record in, the batch's linger runs from here
 }
 
 func (r *RecordBatch) Reset() {
 	r.offset = 0
 	r.Count = 0
+	r.started = time.Time{}
 }
 
 func (r *RecordBatch) NextRecord(size int) ([]byte, error) {
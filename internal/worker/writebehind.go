@@ -2,58 +2,111 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const (
+	// Transaction IDs are kept in the dedup ledger for as long as Kafka may redeliver them (default topic retention)
+	dedupRetention = 7 * 24 * time.Hour
+
+	// Daily transactions_history partitions are created this many days ahead so archiving never hits a missing range.
+	// Older rows, archived when write-behind falls behind, land in transactions_history_default.
+	historyPartitionsAhead    = 7
+	historyPartitionsInterval = time.Hour
+)
+
 type WriteBehindWorker struct {
-	log          *slog.Logger
-	pool         *pgxpool.Pool
-	minPartition int
-	maxPartition int
-	idx          int
-	cancel       context.CancelFunc
+	log        *slog.Logger
+	pool       *pgxpool.Pool
+	mu         sync.Mutex
+	partitions []int // Partitions written behind in turn, changes as a consumer group reassigns them
+	idx        int
+	interval   time.Duration
+	cancel     context.CancelFunc
 }
 
-func NewWriteBehindWorker(log *slog.Logger, pool *pgxpool.Pool, minPartition int, maxPartition int) *WriteBehindWorker {
-	return &WriteBehindWorker{
-		log:          log,
-		pool:         pool,
-		minPartition: minPartition,
-		maxPartition: maxPartition,
-		idx:          minPartition,
+func NewWriteBehindWorker(log *slog.Logger, pool *pgxpool.Pool, minPartition int, maxPartition int, interval time.Duration) *WriteBehindWorker {
+	w := &WriteBehindWorker{
+		log:      log,
+		pool:     pool,
+		interval: interval,
+	}
+	for i := minPartition; i <= maxPartition; i++ {
+		w.partitions = append(w.partitions, i)
 	}
+	return w
 }
-func (w *WriteBehindWorker) Start(ctx context.Context) {
-	go w.run(ctx)
+
+// Replaces the partitions written behind, the next pass starts from the first of them
+func (w *WriteBehindWorker) SetPartitions(partitions []int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partitions = partitions
+	w.idx = 0
 }
 
-func (w *WriteBehindWorker) run(ctx context.Context) error {
+// Returns the partition to write behind next and whether it starts a pass, false when there are none
+func (w *WriteBehindWorker) next() (int, bool, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partitions) == 0 {
+		return 0, false, false
+	}
+	if w.idx >= len(w.partitions) {
+		w.idx = 0
+	}
+	i, first := w.partitions[w.idx], w.idx == 0
+	w.idx++
+	return i, first, true
+}
+func (w *WriteBehindWorker) Start(ctx context.Context) {
 	var writeBehindCtx context.Context
 	writeBehindCtx, w.cancel = context.WithCancel(ctx)
+	go w.run(writeBehindCtx)
+}
 
-	ticker := time.NewTicker(2 * time.Second)
+func (w *WriteBehindWorker) run(writeBehindCtx context.Context) error {
+	ticker := time.NewTicker(w.interval)
 	defer ticker.Stop()
 
-	w.log.Debug("Write behind worker started", slog.Int("min_partition", w.minPartition), slog.Int("max_partition", w.maxPartition))
+	if err := w.ensureHistoryPartitions(time.Now()); err != nil {
+		w.log.Error("History partition error", slog.Any("error", err))
+	}
+	partitionTicker := time.NewTicker(historyPartitionsInterval)
+	defer partitionTicker.Stop()
+
+	w.log.Debug("Write behind worker started")
 
 	for {
 		select {
 		case <-writeBehindCtx.Done():
 			w.log.Info("Write behind worker stopping")
 			return nil
+		case <-partitionTicker.C:
+			if err := w.ensureHistoryPartitions(time.Now()); err != nil {
+				w.log.Error("History partition error", slog.Any("error", err))
+			}
 		case <-ticker.C:
-			if err := w.writeBehind(w.idx); err != nil {
-				w.log.Error("Write behind error", slog.Int("partition", w.idx), slog.Any("error", err))
+			i, first, ok := w.next()
+			if !ok {
+				continue
+			}
+			if err := w.writeBehind(i); err != nil {
+				w.log.Error("Write behind error", slog.Int("partition", i), slog.Any("error", err))
 			} else {
-				w.log.Info("Write behind completed", slog.Int("partition", w.idx))
+				w.log.Info("Write behind completed", slog.Int("partition", i))
+			}
+			if err := w.purgeTransactionIDs(i); err != nil {
+				w.log.Error("Transaction ID purge error", slog.Int("partition", i), slog.Any("error", err))
 			}
-			w.idx++
-			if w.idx > w.maxPartition {
-				w.idx = w.minPartition
+			if err := w.purgeBatches(i, first); err != nil {
+				w.log.Error("Batch purge error", slog.Int("partition", i), slog.Any("error", err))
 			}
 		}
 	}
@@ -84,50 +137,132 @@ func (w *WriteBehindWorker) writeBehind(i int) error {
 	}
 
 	tx, err := w.pool.Begin(timeoutCtx)
-	defer tx.Rollback(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction for partition %d: %v", i, err)
 	}
+	defer tx.Rollback(context.Background())
 
+	// Only rows deleted by this statement are aggregated, so rows merged concurrently after its snapshot are left
+	// in place for the next pass instead of being cleared without ever being applied. The same rows are archived
+	// in the same statement, so a transaction is either pending in transactions_N or in transactions_history.
+	// Accounts are locked in ID order before they are updated, the order the worker's funds check locks them in,
+	// so the two cannot deadlock.
 	update := fmt.Sprintf(`
-		WITH aggregated_batch AS (
+		WITH applied AS (
+				DELETE FROM transactions_%[1]d
+				RETURNING id, account_id, amount, created_at, occurred_at, external_ref, metadata, batch_id
+		), archived AS (
+				INSERT INTO transactions_history (id, account_id, amount, created_at, occurred_at, external_ref, metadata)
+				SELECT id, account_id, amount, created_at, occurred_at, external_ref, metadata FROM applied
+				RETURNING 1
+		), aggregated_batch AS (
 				SELECT 
 						account_id, 
 						SUM(amount) as net_change
-				FROM transactions_%d
+				FROM applied
 				GROUP BY account_id
+		), locked AS (
+				SELECT accounts.id, aggregated_batch.net_change
+				FROM accounts
+				JOIN aggregated_batch ON accounts.id = aggregated_batch.account_id
+				ORDER BY accounts.id
+				FOR UPDATE OF accounts
+		), updated AS (
+				UPDATE accounts
+				SET balance = accounts.balance + locked.net_change
+				FROM locked
+				WHERE accounts.id = locked.id
+				RETURNING 1
+		), progress AS (
+				INSERT INTO batch_progress AS p (batch_id, partition_id, written_behind, updated_at)
+				SELECT batch_id, %[1]d, COUNT(*), NOW()
+				FROM applied
+				WHERE batch_id IS NOT NULL
+				GROUP BY batch_id
+				ON CONFLICT (batch_id, partition_id) DO UPDATE SET
+						written_behind = p.written_behind + EXCLUDED.written_behind,
+						updated_at = EXCLUDED.updated_at
 		)
-		UPDATE accounts
-		SET balance = accounts.balance + aggregated_batch.net_change
-		FROM aggregated_batch
-		WHERE accounts.id = aggregated_batch.account_id;
+		SELECT (SELECT COUNT(*) FROM archived), (SELECT COUNT(*) FROM updated);
 	`, i)
 
-	_, err = tx.Exec(timeoutCtx, update)
+	start := time.Now()
+	var archivedCount, updatedCount int64
+	err = tx.QueryRow(timeoutCtx, update).Scan(&archivedCount, &updatedCount)
 	if err != nil {
 		return fmt.Errorf("failed to update accounts for partition %d: %v", i, err)
 	}
 
-	// Need a better archive strategy
-	// archive := fmt.Sprintf(`
-	// 	INSERT INTO transactions_history (id, account_id, amount, created_at)
-	// 	SELECT id, account_id, amount, created_at FROM transactions_%d
-	// 	ON CONFLICT (id) DO NOTHING;
-	// `, i)
-	// _, err = tx.Exec(timeoutCtx, archive)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to archive transactions for partition %d: %v", i, err)
-	// }
-
-	clear := fmt.Sprintf(`TRUNCATE transactions_%d`, i)
-	_, err = tx.Exec(timeoutCtx, clear)
+	err = tx.Commit(timeoutCtx)
 	if err != nil {
-		return fmt.Errorf("failed to clear transactions for partition %d: %v", i, err)
+		return fmt.Errorf("failed to write behind for partition %d: %v", i, err)
 	}
 
-	err = tx.Commit(timeoutCtx)
+	writeBehindLatency.Observe(time.Since(start).Seconds())
+	transactionsArchived.Add(float64(archivedCount))
+	w.log.Debug("Archived transactions", slog.Int("partition", i), slog.Int64("archived", archivedCount), slog.Int64("accounts", updatedCount))
+	return nil
+}
+
+/*
+** Creates the daily partitions around today. A day whose rows already went to the default partition cannot get its
+** own, that failure is reported without stopping the later days from being created.
+ */
+func (w *WriteBehindWorker) ensureHistoryPartitions(now time.Time) error {
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var errs []error
+	today := now.UTC().Truncate(24 * time.Hour)
+	for d := -1; d <= historyPartitionsAhead; d++ {
+		from := today.AddDate(0, 0, d)
+		to := from.AddDate(0, 0, 1)
+		create := fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS transactions_history_%s PARTITION OF transactions_history FOR VALUES FROM ('%s') TO ('%s')`,
+			from.Format("20060102"),
+			from.Format(time.RFC3339),
+			to.Format(time.RFC3339),
+		)
+		if _, err := w.pool.Exec(timeoutCtx, create); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create history partition for %s: %v", from.Format(time.DateOnly), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (w *WriteBehindWorker) purgeTransactionIDs(i int) error {
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const purge = `DELETE FROM transaction_ids WHERE partition_id = $1 AND created_at < $2`
+	tag, err := w.pool.Exec(timeoutCtx, purge, i, time.Now().Add(-dedupRetention))
 	if err != nil {
-		return fmt.Errorf("failed to write behind for partition %d: %v", i, err)
+		return fmt.Errorf("failed to purge transaction ids for partition %d: %v", i, err)
+	}
+
+	w.log.Debug("Purged expired transaction ids", slog.Int("partition", i), slog.Int64("count", tag.RowsAffected()))
+	return nil
+}
+
+// Batch status is only kept as long as the transaction IDs it could be reconciled against
+func (w *WriteBehindWorker) purgeBatches(i int, first bool) error {
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cutoff := time.Now().Add(-dedupRetention)
+	const purgeProgress = `DELETE FROM batch_progress WHERE partition_id = $1 AND updated_at < $2`
+	if _, err := w.pool.Exec(timeoutCtx, purgeProgress, i, cutoff); err != nil {
+		return fmt.Errorf("failed to purge batch progress for partition %d: %v", i, err)
+	}
+
+	// Batch rows are not partitioned, purge them once per pass over the shard's partitions
+	if !first {
+		return nil
+	}
+	const purgeBatches = `DELETE FROM batches WHERE created_at < $1`
+	if _, err := w.pool.Exec(timeoutCtx, purgeBatches, cutoff); err != nil {
+		return fmt.Errorf("failed to purge batches: %v", err)
 	}
 	return nil
 }
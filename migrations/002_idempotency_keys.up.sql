@@ -0,0 +1,12 @@
+-- Remembers Idempotency-Key headers so retried ingestion requests replay the
+-- original response instead of producing the batch again
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  key TEXT PRIMARY KEY,
+  request_hash BYTEA NOT NULL,
+  status_code INT,
+  response BYTEA,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
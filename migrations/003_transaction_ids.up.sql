@@ -0,0 +1,22 @@
+-- Durable deduplication ledger of every transaction ID merged by the workers.
+-- Unlike transactions_N it is not cleared by write-behind, so redelivered
+-- records are rejected for the whole retention window.
+CREATE TABLE IF NOT EXISTS transaction_ids (
+  id UUID PRIMARY KEY,
+  partition_id SMALLINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transaction_ids_partition_created_at_idx ON transaction_ids (partition_id, created_at);
+
+-- Backfill IDs that are merged but not yet written behind
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      INSERT INTO transaction_ids (id, partition_id, created_at)
+      SELECT id, %s, created_at FROM transactions_%s
+      ON CONFLICT (id) DO NOTHING;
+    ', i, i);
+  END LOOP;
+END $$;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS transactions_history;
+
+CREATE TABLE IF NOT EXISTS transactions_history (
+  id UUID PRIMARY KEY,
+  account_id UUID NOT NULL,
+  amount BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,42 @@
+-- Archive of transactions applied by write-behind, range partitioned by day on
+-- created_at. Workers create upcoming partitions automatically; the ones below
+-- cover the days around the migration so archiving works immediately.
+-- The unpartitioned table is replaced rather than converted, refuse to drop
+-- rows that are already archived.
+DO $$
+DECLARE
+  has_rows BOOLEAN;
+BEGIN
+  IF to_regclass('transactions_history') IS NOT NULL THEN
+    EXECUTE 'SELECT EXISTS (SELECT 1 FROM transactions_history)' INTO has_rows;
+    IF has_rows THEN
+      RAISE EXCEPTION 'transactions_history holds rows, move them out before partitioning it';
+    END IF;
+  END IF;
+END $$;
+
+DROP TABLE IF EXISTS transactions_history;
+
+CREATE TABLE transactions_history (
+  id UUID NOT NULL,
+  account_id UUID NOT NULL,
+  amount BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX IF NOT EXISTS transactions_history_id_idx ON transactions_history (id);
+
+DO $$
+DECLARE
+  d DATE;
+BEGIN
+  FOR i IN -1..7 LOOP
+    d := (NOW() AT TIME ZONE 'UTC')::date + i;
+    EXECUTE format(
+      'CREATE TABLE IF NOT EXISTS transactions_history_%s PARTITION OF transactions_history FOR VALUES FROM (%L) TO (%L)',
+      to_char(d, 'YYYYMMDD'),
+      d::timestamp AT TIME ZONE 'UTC',
+      (d + 1)::timestamp AT TIME ZONE 'UTC'
+    );
+  END LOOP;
+END $$;
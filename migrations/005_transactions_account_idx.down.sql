@@ -0,0 +1,6 @@
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('DROP INDEX IF EXISTS transactions_%s_account_id_idx;', i);
+  END LOOP;
+END $$;
@@ -0,0 +1,7 @@
+-- Account reads sum the pending, not yet written behind, amounts per account
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('CREATE INDEX IF NOT EXISTS transactions_%s_account_id_idx ON transactions_%s (account_id);', i, i);
+  END LOOP;
+END $$;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS rejected_transactions;
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS status;
@@ -0,0 +1,17 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+  CONSTRAINT accounts_status_check CHECK (status IN ('active', 'frozen', 'closed'));
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Transactions the workers refused to merge, kept for investigation instead
+-- of being applied to a balance
+CREATE TABLE IF NOT EXISTS rejected_transactions (
+  id UUID PRIMARY KEY,
+  account_id UUID NOT NULL,
+  amount BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  partition_id SMALLINT NOT NULL,
+  reason TEXT NOT NULL,
+  rejected_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS rejected_transactions_account_id_idx ON rejected_transactions (account_id);
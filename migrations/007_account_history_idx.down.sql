@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS transactions_history_account_created_at_idx;
+
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      DROP INDEX IF EXISTS transactions_%s_account_created_at_idx;
+      CREATE INDEX IF NOT EXISTS transactions_%s_account_id_idx ON transactions_%s (account_id);
+    ', i, i, i);
+  END LOOP;
+END $$;
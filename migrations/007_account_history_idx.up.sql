@@ -0,0 +1,13 @@
+-- Per-account transaction history is read newest first with a (created_at, id)
+-- keyset cursor, from both the live partition tables and the archive
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      DROP INDEX IF EXISTS transactions_%s_account_id_idx;
+      CREATE INDEX IF NOT EXISTS transactions_%s_account_created_at_idx ON transactions_%s (account_id, created_at DESC, id DESC);
+    ', i, i, i);
+  END LOOP;
+END $$;
+
+CREATE INDEX IF NOT EXISTS transactions_history_account_created_at_idx ON transactions_history (account_id, created_at DESC, id DESC);
@@ -0,0 +1,8 @@
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('ALTER TABLE staging_%s DROP COLUMN IF EXISTS reject_reason;', i);
+  END LOOP;
+END $$;
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS min_balance;
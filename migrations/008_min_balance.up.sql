@@ -0,0 +1,11 @@
+-- Lowest balance an account may reach. 0 disallows overdrafts, a negative
+-- value is the overdraft limit.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS min_balance BIGINT NOT NULL DEFAULT 0;
+
+-- Set by the worker for rows that failed the funds check before the merge
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('ALTER TABLE staging_%s ADD COLUMN IF NOT EXISTS reject_reason TEXT;', i);
+  END LOOP;
+END $$;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS journal_legs;
+DROP TABLE IF EXISTS journal_entries;
@@ -0,0 +1,18 @@
+-- Double-entry transfers are recorded on the shard owning the entry ID. The
+-- legs themselves are merged into transactions_N on the shards owning their
+-- accounts, committed together with this row by two-phase commit.
+CREATE TABLE IF NOT EXISTS journal_entries (
+  id UUID PRIMARY KEY,
+  status TEXT NOT NULL CONSTRAINT journal_entries_status_check CHECK (status IN ('applied', 'rejected')),
+  reason TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS journal_legs (
+  journal_id UUID NOT NULL REFERENCES journal_entries (id) ON DELETE CASCADE,
+  leg_index SMALLINT NOT NULL,
+  transaction_id UUID NOT NULL,
+  account_id UUID NOT NULL,
+  amount BIGINT NOT NULL,
+  PRIMARY KEY (journal_id, leg_index)
+);
@@ -0,0 +1,11 @@
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('ALTER TABLE staging_%s DROP COLUMN IF EXISTS currency;', i);
+  END LOOP;
+END $$;
+
+ALTER TABLE journal_entries DROP COLUMN IF EXISTS currency;
+ALTER TABLE rejected_transactions DROP COLUMN IF EXISTS currency;
+ALTER TABLE accounts DROP COLUMN IF EXISTS currency;
+DROP TABLE IF EXISTS currencies;
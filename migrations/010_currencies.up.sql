@@ -0,0 +1,33 @@
+-- Amounts are integers in the currency's minor unit, e.g. cents for USD
+CREATE TABLE IF NOT EXISTS currencies (
+  code TEXT PRIMARY KEY CONSTRAINT currencies_code_check CHECK (code ~ '^[A-Z]{3}$'),
+  minor_units SMALLINT NOT NULL CONSTRAINT currencies_minor_units_check CHECK (minor_units BETWEEN 0 AND 18)
+);
+
+INSERT INTO currencies (code, minor_units) VALUES
+  ('USD', 2),
+  ('EUR', 2),
+  ('GBP', 2),
+  ('CHF', 2),
+  ('CAD', 2),
+  ('AUD', 2),
+  ('JPY', 0),
+  ('KRW', 0),
+  ('BHD', 3),
+  ('KWD', 3)
+ON CONFLICT (code) DO NOTHING;
+
+-- Existing accounts predate currencies and are assumed to be USD
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD'
+  CONSTRAINT accounts_currency_fkey REFERENCES currencies (code);
+
+ALTER TABLE rejected_transactions ADD COLUMN IF NOT EXISTS currency TEXT;
+ALTER TABLE journal_entries ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+
+-- Currency sent with each transaction, NULL when the sender left it unspecified
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('ALTER TABLE staging_%s ADD COLUMN IF NOT EXISTS currency TEXT;', i);
+  END LOOP;
+END $$;
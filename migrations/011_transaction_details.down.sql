@@ -0,0 +1,26 @@
+DROP INDEX IF EXISTS rejected_transactions_external_ref_idx;
+DROP INDEX IF EXISTS transactions_history_external_ref_idx;
+DROP INDEX IF EXISTS transaction_ids_external_ref_idx;
+ALTER TABLE transaction_ids DROP COLUMN IF EXISTS external_ref;
+
+ALTER TABLE rejected_transactions DROP COLUMN IF EXISTS metadata;
+ALTER TABLE rejected_transactions DROP COLUMN IF EXISTS external_ref;
+ALTER TABLE rejected_transactions DROP COLUMN IF EXISTS occurred_at;
+
+ALTER TABLE transactions_history DROP COLUMN IF EXISTS metadata;
+ALTER TABLE transactions_history DROP COLUMN IF EXISTS external_ref;
+ALTER TABLE transactions_history DROP COLUMN IF EXISTS occurred_at;
+
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      ALTER TABLE transactions_%s DROP COLUMN IF EXISTS metadata;
+      ALTER TABLE transactions_%s DROP COLUMN IF EXISTS external_ref;
+      ALTER TABLE transactions_%s DROP COLUMN IF EXISTS occurred_at;
+      ALTER TABLE staging_%s DROP COLUMN IF EXISTS metadata;
+      ALTER TABLE staging_%s DROP COLUMN IF EXISTS external_ref;
+      ALTER TABLE staging_%s DROP COLUMN IF EXISTS occurred_at;
+    ', i, i, i, i, i, i);
+  END LOOP;
+END $$;
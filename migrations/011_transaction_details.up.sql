@@ -0,0 +1,30 @@
+-- Optional client supplied details. occurred_at is when the payment happened
+-- upstream, created_at stays the time the ledger accepted it.
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      ALTER TABLE staging_%s ADD COLUMN IF NOT EXISTS occurred_at TIMESTAMPTZ;
+      ALTER TABLE staging_%s ADD COLUMN IF NOT EXISTS external_ref TEXT;
+      ALTER TABLE staging_%s ADD COLUMN IF NOT EXISTS metadata JSONB;
+      ALTER TABLE transactions_%s ADD COLUMN IF NOT EXISTS occurred_at TIMESTAMPTZ;
+      ALTER TABLE transactions_%s ADD COLUMN IF NOT EXISTS external_ref TEXT;
+      ALTER TABLE transactions_%s ADD COLUMN IF NOT EXISTS metadata JSONB;
+    ', i, i, i, i, i, i);
+  END LOOP;
+END $$;
+
+ALTER TABLE transactions_history ADD COLUMN IF NOT EXISTS occurred_at TIMESTAMPTZ;
+ALTER TABLE transactions_history ADD COLUMN IF NOT EXISTS external_ref TEXT;
+ALTER TABLE transactions_history ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+ALTER TABLE rejected_transactions ADD COLUMN IF NOT EXISTS occurred_at TIMESTAMPTZ;
+ALTER TABLE rejected_transactions ADD COLUMN IF NOT EXISTS external_ref TEXT;
+ALTER TABLE rejected_transactions ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+-- Reconciliation looks transactions up by external_ref. The ledger routes
+-- recent ones to their partition table, older ones are found in the archive.
+ALTER TABLE transaction_ids ADD COLUMN IF NOT EXISTS external_ref TEXT;
+CREATE INDEX IF NOT EXISTS transaction_ids_external_ref_idx ON transaction_ids (external_ref) WHERE external_ref IS NOT NULL;
+CREATE INDEX IF NOT EXISTS transactions_history_external_ref_idx ON transactions_history (external_ref) WHERE external_ref IS NOT NULL;
+CREATE INDEX IF NOT EXISTS rejected_transactions_external_ref_idx ON rejected_transactions (external_ref) WHERE external_ref IS NOT NULL;
@@ -0,0 +1,12 @@
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      ALTER TABLE transactions_%s DROP COLUMN IF EXISTS batch_id;
+      ALTER TABLE staging_%s DROP COLUMN IF EXISTS batch_id;
+    ', i, i);
+  END LOOP;
+END $$;
+
+DROP TABLE IF EXISTS batch_progress;
+DROP TABLE IF EXISTS batches;
@@ -0,0 +1,36 @@
+-- One row per ingestion request, written by the API once its records are
+-- produced. Kept on the shard the batch ID maps to.
+CREATE TABLE IF NOT EXISTS batches (
+  id UUID PRIMARY KEY,
+  produced INT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS batches_created_at_idx ON batches (created_at);
+
+-- Worker progress on a batch, one row per partition its records landed in.
+-- Updated in the same transaction as the merge and the write-behind.
+CREATE TABLE IF NOT EXISTS batch_progress (
+  batch_id UUID NOT NULL,
+  partition_id SMALLINT NOT NULL,
+  staged INT NOT NULL DEFAULT 0,
+  merged INT NOT NULL DEFAULT 0,
+  rejected INT NOT NULL DEFAULT 0,
+  written_behind INT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (batch_id, partition_id)
+);
+
+CREATE INDEX IF NOT EXISTS batch_progress_partition_updated_at_idx ON batch_progress (partition_id, updated_at);
+
+-- Batch each record was produced in, NULL for records produced without one
+-- and for transfer legs
+DO $$
+BEGIN
+  FOR i IN 0..63 LOOP
+    EXECUTE format('
+      ALTER TABLE staging_%s ADD COLUMN IF NOT EXISTS batch_id UUID;
+      ALTER TABLE transactions_%s ADD COLUMN IF NOT EXISTS batch_id UUID;
+    ', i, i);
+  END LOOP;
+END $$;
@@ -0,0 +1 @@
+ALTER TABLE kafka_offsets DROP COLUMN IF EXISTS epoch;
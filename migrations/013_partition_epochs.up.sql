@@ -0,0 +1,4 @@
+-- Fencing for partition writers. A worker bumps the epoch of each partition
+-- it starts writing, and every batch commit checks it, so a worker that has
+-- been replaced fails its writes instead of committing over its successor.
+ALTER TABLE kafka_offsets ADD COLUMN IF NOT EXISTS epoch BIGINT NOT NULL DEFAULT 0;
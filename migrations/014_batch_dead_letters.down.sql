@@ -0,0 +1 @@
+ALTER TABLE batch_progress DROP COLUMN IF EXISTS dead_lettered;
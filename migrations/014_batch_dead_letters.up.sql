@@ -0,0 +1,3 @@
+-- Records of a batch the worker sent to the dead-letter topic instead of
+-- staging, counted with the batch write that moves the offset past them
+ALTER TABLE batch_progress ADD COLUMN IF NOT EXISTS dead_lettered INT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS transactions_history_default;
@@ -0,0 +1,4 @@
+-- Catches rows older than the daily partitions the workers create, such as
+-- those archived after write-behind fell more than a day behind. Without it
+-- the archive insert fails and the partition's write-behind stalls.
+CREATE TABLE IF NOT EXISTS transactions_history_default PARTITION OF transactions_history DEFAULT;
@@ -26,6 +26,10 @@ type Transaction struct {
 	Id            []byte                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	AccountId     []byte                 `protobuf:"bytes,2,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`
 	Amount        int64                  `protobuf:"varint,3,opt,name=amount,proto3" json:"amount,omitempty"`
+	Currency      string                 `protobuf:"bytes,4,opt,name=currency,proto3" json:"currency,omitempty"`                          // ISO 4217 code, empty applies the amount in the account's currency
+	OccurredAt    int64                  `protobuf:"varint,5,opt,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`   // Microseconds since the Unix epoch when the payment happened, 0 when unknown
+	ExternalRef   string                 `protobuf:"bytes,6,opt,name=external_ref,json=externalRef,proto3" json:"external_ref,omitempty"` // Reference in the upstream payment processor, used for reconciliation
+	Metadata      []byte                 `protobuf:"bytes,7,opt,name=metadata,proto3" json:"metadata,omitempty"`                          // Free-form JSON object
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -81,6 +85,34 @@ func (x *Transaction) GetAmount() int64 {
 	return 0
 }
 
+func (x *Transaction) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Transaction) GetOccurredAt() int64 {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return 0
+}
+
+func (x *Transaction) GetExternalRef() string {
+	if x != nil {
+		return x.ExternalRef
+	}
+	return ""
+}
+
+func (x *Transaction) GetMetadata() []byte {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
 type TransactionBatch struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Transactions  []*Transaction         `protobuf:"bytes,1,rep,name=transactions,proto3" json:"transactions,omitempty"`
@@ -125,18 +157,145 @@ func (x *TransactionBatch) GetTransactions() []*Transaction {
 	return nil
 }
 
+// Double-entry transfer, either every leg is applied or none are. Leg amounts must net to zero.
+type JournalEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            []byte                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Legs          []*JournalLeg          `protobuf:"bytes,2,rep,name=legs,proto3" json:"legs,omitempty"`
+	Currency      string                 `protobuf:"bytes,3,opt,name=currency,proto3" json:"currency,omitempty"` // Every leg's account must hold this currency
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JournalEntry) Reset() {
+	*x = JournalEntry{}
+	mi := &file_proto_transaction_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JournalEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JournalEntry) ProtoMessage() {}
+
+func (x *JournalEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transaction_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JournalEntry.ProtoReflect.Descriptor instead.
+func (*JournalEntry) Descriptor() ([]byte, []int) {
+	return file_proto_transaction_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *JournalEntry) GetId() []byte {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
+
+func (x *JournalEntry) GetLegs() []*JournalLeg {
+	if x != nil {
+		return x.Legs
+	}
+	return nil
+}
+
+func (x *JournalEntry) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+type JournalLeg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AccountId     []byte                 `protobuf:"bytes,1,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`
+	Amount        int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"` // Negative for a debit, positive for a credit
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JournalLeg) Reset() {
+	*x = JournalLeg{}
+	mi := &file_proto_transaction_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JournalLeg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JournalLeg) ProtoMessage() {}
+
+func (x *JournalLeg) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transaction_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JournalLeg.ProtoReflect.Descriptor instead.
+func (*JournalLeg) Descriptor() ([]byte, []int) {
+	return file_proto_transaction_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *JournalLeg) GetAccountId() []byte {
+	if x != nil {
+		return x.AccountId
+	}
+	return nil
+}
+
+func (x *JournalLeg) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
 var File_proto_transaction_proto protoreflect.FileDescriptor
 
 const file_proto_transaction_proto_rawDesc = "" +
 	"\n" +
-	"\x17proto/transaction.proto\x12\vtransaction\"T\n" +
+	"\x17proto/transaction.proto\x12\vtransaction\"\xd0\x01\n" +
 	"\vTransaction\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\fR\x02id\x12\x1d\n" +
 	"\n" +
 	"account_id\x18\x02 \x01(\fR\taccountId\x12\x16\n" +
-	"\x06amount\x18\x03 \x01(\x03R\x06amount\"P\n" +
+	"\x06amount\x18\x03 \x01(\x03R\x06amount\x12\x1a\n" +
+	"\bcurrency\x18\x04 \x01(\tR\bcurrency\x12\x1f\n" +
+	"\voccurred_at\x18\x05 \x01(\x03R\n" +
+	"occurredAt\x12!\n" +
+	"\fexternal_ref\x18\x06 \x01(\tR\vexternalRef\x12\x1a\n" +
+	"\bmetadata\x18\a \x01(\fR\bmetadata\"P\n" +
 	"\x10TransactionBatch\x12<\n" +
-	"\ftransactions\x18\x01 \x03(\v2\x18.transaction.TransactionR\ftransactionsB2Z0github.com/alexmcook/transaction-ledger/proto;pbb\x06proto3"
+	"\ftransactions\x18\x01 \x03(\v2\x18.transaction.TransactionR\ftransactions\"g\n" +
+	"\fJournalEntry\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\fR\x02id\x12+\n" +
+	"\x04legs\x18\x02 \x03(\v2\x17.transaction.JournalLegR\x04legs\x12\x1a\n" +
+	"\bcurrency\x18\x03 \x01(\tR\bcurrency\"C\n" +
+	"\n" +
+	"JournalLeg\x12\x1d\n" +
+	"\n" +
+	"account_id\x18\x01 \x01(\fR\taccountId\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x03R\x06amountB2Z0github.com/alexmcook/transaction-ledger/proto;pbb\x06proto3"
 
 var (
 	file_proto_transaction_proto_rawDescOnce sync.Once
@@ -150,18 +309,21 @@ func file_proto_transaction_proto_rawDescGZIP() []byte {
 	return file_proto_transaction_proto_rawDescData
 }
 
-var file_proto_transaction_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_transaction_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_proto_transaction_proto_goTypes = []any{
 	(*Transaction)(nil),      // 0: transaction.Transaction
 	(*TransactionBatch)(nil), // 1: transaction.TransactionBatch
+	(*JournalEntry)(nil),     // 2: transaction.JournalEntry
+	(*JournalLeg)(nil),       // 3: transaction.JournalLeg
 }
 var file_proto_transaction_proto_depIdxs = []int32{
 	0, // 0: transaction.TransactionBatch.transactions:type_name -> transaction.Transaction
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	3, // 1: transaction.JournalEntry.legs:type_name -> transaction.JournalLeg
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_transaction_proto_init() }
@@ -175,7 +337,7 @@ func file_proto_transaction_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_transaction_proto_rawDesc), len(file_proto_transaction_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   0,
 		},